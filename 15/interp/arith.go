@@ -0,0 +1,477 @@
+package interp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// arithOps lists the arithmetic operators, longest first.
+var arithOps = []string{
+	"<<=", ">>=",
+	"**", "<<", ">>", "<=", ">=", "==", "!=", "&&", "||", "++", "--",
+	"+=", "-=", "*=", "/=", "%=", "&=", "^=", "|=",
+	"+", "-", "*", "/", "%", "<", ">", "&", "^", "|", "!", "~", "?", ":", "=", "(", ")", ",",
+}
+
+type arithError struct {
+	msg string
+}
+
+type arith struct {
+	// r is the shell whose variables the expression reads and assigns.
+	r      *Runner
+	src    string
+	pos    int
+	noeval int
+	depth  int
+}
+
+// evalArith evaluates a $(( )) expression with C integer semantics.
+func (r *Runner) evalArith(src string) (n int64, err error) {
+	return r.evalArithDepth(src, 0)
+}
+
+func (r *Runner) evalArithDepth(src string, depth int) (n int64, err error) {
+	a := &arith{r: r, src: src, depth: depth}
+	defer func() {
+		if e := recover(); e != nil {
+			ae, ok := e.(*arithError)
+			if !ok {
+				panic(e)
+			}
+			n, err = 0, fmt.Errorf("%s: %s", strings.TrimSpace(src), ae.msg)
+		}
+	}()
+	if strings.TrimSpace(src) == "" {
+		return 0, nil
+	}
+	n = a.comma()
+	if tok := a.peek(); tok != "" {
+		a.fail("syntax error in expression (error token is %q)", a.src[a.pos:])
+	}
+	return n, nil
+}
+
+func (a *arith) fail(format string, args ...any) {
+	panic(&arithError{msg: fmt.Sprintf(format, args...)})
+}
+
+func (a *arith) skipSpace() {
+	for a.pos < len(a.src) && strings.IndexByte(" \t\n", a.src[a.pos]) >= 0 {
+		a.pos++
+	}
+}
+
+// peek returns the operator at the current position, or "" if there is
+// none.
+func (a *arith) peek() string {
+	a.skipSpace()
+	rest := a.src[a.pos:]
+	for _, op := range arithOps {
+		if strings.HasPrefix(rest, op) {
+			return op
+		}
+	}
+	if rest != "" {
+		return rest[:1]
+	}
+	return ""
+}
+
+func (a *arith) accept(ops ...string) string {
+	tok := a.peek()
+	for _, op := range ops {
+		if tok == op {
+			a.pos += len(op)
+			return op
+		}
+	}
+	return ""
+}
+
+func (a *arith) expect(op string) {
+	if a.accept(op) == "" {
+		a.fail("syntax error: `%s' expected (error token is %q)", op, a.src[a.pos:])
+	}
+}
+
+func (a *arith) comma() int64 {
+	v := a.assign()
+	for a.accept(",") != "" {
+		v = a.assign()
+	}
+	return v
+}
+
+var assignOps = []string{"=", "+=", "-=", "*=", "/=", "%=", "<<=", ">>=", "&=", "^=", "|="}
+
+func (a *arith) assign() int64 {
+	// Look ahead for an assignment without evaluating the subscript, which
+	// is evaluated once, for real, whichever way the expression is read.
+	save := a.pos
+	a.noeval++
+	_, ok := a.lvalue()
+	isAssign := ok && a.accept(assignOps...) != ""
+	a.noeval--
+	a.pos = save
+	if !isAssign {
+		return a.ternary()
+	}
+	lv, _ := a.lvalue()
+	op := a.accept(assignOps...)
+	rhs := a.assign()
+	v := rhs
+	if op != "=" {
+		v = a.binary(strings.TrimSuffix(op, "="), a.variable(lv), rhs)
+	}
+	a.store(lv, v)
+	return v
+}
+
+func (a *arith) ternary() int64 {
+	cond := a.logOr()
+	if a.accept("?") == "" {
+		return cond
+	}
+	if cond == 0 {
+		a.noeval++
+	}
+	t := a.assign()
+	if cond == 0 {
+		a.noeval--
+	}
+	a.expect(":")
+	if cond != 0 {
+		a.noeval++
+	}
+	f := a.assign()
+	if cond != 0 {
+		a.noeval--
+	}
+	if cond != 0 {
+		return t
+	}
+	return f
+}
+
+func (a *arith) logOr() int64 {
+	v := a.logAnd()
+	for a.accept("||") != "" {
+		if v != 0 {
+			a.noeval++
+			a.logAnd()
+			a.noeval--
+			v = 1
+			continue
+		}
+		v = boolInt(a.logAnd() != 0)
+	}
+	return v
+}
+
+func (a *arith) logAnd() int64 {
+	v := a.binaryLevel(0)
+	for a.accept("&&") != "" {
+		if v == 0 {
+			a.noeval++
+			a.binaryLevel(0)
+			a.noeval--
+			continue
+		}
+		v = boolInt(a.binaryLevel(0) != 0)
+	}
+	return v
+}
+
+// binaryLevels holds the left-associative operators from loosest to
+// tightest binding.
+var binaryLevels = [][]string{
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (a *arith) binaryLevel(level int) int64 {
+	if level == len(binaryLevels) {
+		return a.power()
+	}
+	v := a.binaryLevel(level + 1)
+	for {
+		op := a.accept(binaryLevels[level]...)
+		if op == "" {
+			return v
+		}
+		v = a.binary(op, v, a.binaryLevel(level+1))
+	}
+}
+
+func (a *arith) power() int64 {
+	base := a.unary()
+	if a.accept("**") == "" {
+		return base
+	}
+	exp := a.power()
+	if a.noeval > 0 {
+		return 0
+	}
+	if exp < 0 {
+		a.fail("exponent less than 0")
+	}
+	// Square and multiply, wrapping around on overflow like bash.
+	v := int64(1)
+	for ; exp > 0; exp >>= 1 {
+		if exp&1 != 0 {
+			v *= base
+		}
+		base *= base
+	}
+	return v
+}
+
+func (a *arith) unary() int64 {
+	switch a.accept("!", "~", "+", "-", "++", "--") {
+	case "!":
+		return boolInt(a.unary() == 0)
+	case "~":
+		return ^a.unary()
+	case "+":
+		return a.unary()
+	case "-":
+		return -a.unary()
+	case "++", "--":
+		op := a.src[a.pos-2 : a.pos]
+		lv, ok := a.lvalue()
+		if !ok {
+			a.fail("syntax error: operand expected (error token is %q)", a.src[a.pos:])
+		}
+		v := a.variable(lv)
+		if op == "++" {
+			v++
+		} else {
+			v--
+		}
+		a.store(lv, v)
+		return v
+	}
+	return a.postfix()
+}
+
+func (a *arith) postfix() int64 {
+	save := a.pos
+	if lv, ok := a.lvalue(); ok {
+		v := a.variable(lv)
+		switch a.accept("++", "--") {
+		case "++":
+			a.store(lv, v+1)
+		case "--":
+			a.store(lv, v-1)
+		}
+		return v
+	}
+	a.pos = save
+	return a.primary()
+}
+
+func (a *arith) primary() int64 {
+	if a.accept("(") != "" {
+		v := a.comma()
+		a.expect(")")
+		return v
+	}
+	a.skipSpace()
+	start := a.pos
+	for a.pos < len(a.src) && (isAlnumUnderscore(a.src[a.pos]) || a.src[a.pos] == '#') {
+		a.pos++
+	}
+	lit := a.src[start:a.pos]
+	if lit == "" {
+		if a.pos >= len(a.src) {
+			a.fail("syntax error: operand expected")
+		}
+		a.fail("syntax error: operand expected (error token is %q)", a.src[a.pos:])
+	}
+	n, err := parseArithNumber(lit)
+	if err != nil {
+		a.fail("%s: value too great for base (error token is %q)", lit, lit)
+	}
+	return n
+}
+
+// name consumes a variable name, if one starts at the current position.
+func (a *arith) name() string {
+	a.skipSpace()
+	if a.pos >= len(a.src) || !isNameStart(a.src[a.pos]) {
+		return ""
+	}
+	start := a.pos
+	for a.pos < len(a.src) && isAlnumUnderscore(a.src[a.pos]) {
+		a.pos++
+	}
+	return a.src[start:a.pos]
+}
+
+// arithVar is a variable an expression reads or assigns: name, or element
+// index of the array name when elem is set.
+type arithVar struct {
+	name  string
+	elem  bool
+	index int64
+}
+
+// lvalue consumes a variable and its subscript, if one starts at the
+// current position.
+func (a *arith) lvalue() (arithVar, bool) {
+	name := a.name()
+	if name == "" {
+		return arithVar{}, false
+	}
+	lv := arithVar{name: name}
+	if a.pos < len(a.src) && a.src[a.pos] == '[' {
+		a.pos++
+		lv.elem, lv.index = true, a.comma()
+		a.expect("]")
+	}
+	return lv, true
+}
+
+func (a *arith) variable(lv arithVar) int64 {
+	s, _ := a.r.getVar(lv.name)
+	if lv.elem {
+		s, _ = a.r.getElem(lv.name, int(lv.index))
+	}
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0
+	}
+	if n, err := parseArithNumber(s); err == nil {
+		return n
+	}
+	if a.depth > 100 {
+		a.fail("expression recursion level exceeded")
+	}
+	n, err := a.r.evalArithDepth(s, a.depth+1)
+	if err != nil {
+		a.fail("%v", err)
+	}
+	return n
+}
+
+func (a *arith) store(lv arithVar, v int64) {
+	if a.noeval > 0 {
+		return
+	}
+	value := strconv.FormatInt(v, 10)
+	if !lv.elem {
+		a.r.setVar(lv.name, value)
+		return
+	}
+	aa := arrayAssign{name: lv.name, index: int(lv.index), values: []string{value}}
+	if err := a.r.assignArray(aa); err != nil {
+		a.fail("%v", err)
+	}
+}
+
+func (a *arith) binary(op string, x, y int64) int64 {
+	switch op {
+	case "+":
+		return x + y
+	case "-":
+		return x - y
+	case "*":
+		return x * y
+	case "/", "%":
+		if y == 0 {
+			if a.noeval > 0 {
+				return 0
+			}
+			a.fail("division by 0")
+		}
+		if op == "/" {
+			return x / y
+		}
+		return x % y
+	case "<<":
+		return x << uint64(y)
+	case ">>":
+		return x >> uint64(y)
+	case "&":
+		return x & y
+	case "^":
+		return x ^ y
+	case "|":
+		return x | y
+	case "<":
+		return boolInt(x < y)
+	case "<=":
+		return boolInt(x <= y)
+	case ">":
+		return boolInt(x > y)
+	case ">=":
+		return boolInt(x >= y)
+	case "==":
+		return boolInt(x == y)
+	case "!=":
+		return boolInt(x != y)
+	}
+	a.fail("unknown operator %s", op)
+	return 0
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// parseArithNumber parses decimal, 0x hex, leading-zero octal and base#digits
+// constants.
+func parseArithNumber(s string) (int64, error) {
+	if base, digits, ok := strings.Cut(s, "#"); ok {
+		b, err := strconv.Atoi(base)
+		if err != nil || b < 2 || b > 64 {
+			return 0, fmt.Errorf("invalid base")
+		}
+		var n int64
+		for _, c := range digits {
+			var d int
+			switch {
+			case c >= '0' && c <= '9':
+				d = int(c - '0')
+			case c >= 'a' && c <= 'z':
+				d = int(c-'a') + 10
+			case c >= 'A' && c <= 'Z':
+				d = int(c - 'A')
+				if b <= 36 {
+					d += 10
+				} else {
+					d += 36
+				}
+			case c == '@':
+				d = 62
+			case c == '_':
+				d = 63
+			default:
+				return 0, fmt.Errorf("invalid digit")
+			}
+			if d >= b {
+				return 0, fmt.Errorf("invalid digit")
+			}
+			n = n*int64(b) + int64(d)
+		}
+		return n, nil
+	}
+	if len(s) > 1 && s[0] == '0' && s[1] != 'x' && s[1] != 'X' {
+		return strconv.ParseInt(s[1:], 8, 64)
+	}
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		return strconv.ParseInt(s[2:], 16, 64)
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
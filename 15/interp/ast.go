@@ -0,0 +1,248 @@
+package interp
+
+import "fmt"
+
+type Pos struct {
+	Offset int
+	Line   int
+	Col    int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Col)
+}
+
+// List is a sequence of and-or chains separated by ';', '&' or newlines.
+type List struct {
+	Items []*AndOr
+}
+
+type AndOr struct {
+	Pos        Pos
+	Pipelines  []*Pipeline
+	Ops        []string
+	Background bool
+	Text       string
+}
+
+// Pipeline is cmd | cmd ..., negated by a leading ! when Bang is set. Time
+// is set when it is prefixed with time, and TimePosix for time -p.
+type Pipeline struct {
+	Pos       Pos
+	Time      bool
+	TimePosix bool
+	Bang      bool
+	Cmds      []Command
+	Text      string
+}
+
+type Command interface {
+	commandNode()
+}
+
+type SimpleCommand struct {
+	Pos     Pos
+	Assigns []*Assign
+	Args    []*Word
+	Redirs  []*Redirect
+}
+
+// Assign is NAME=value, NAME[index]=value, or NAME=(elements) when Array
+// is set.
+type Assign struct {
+	Pos   Pos
+	Name  string
+	Index *Word
+	Value *Word
+	Array *ArrayLit
+}
+
+// ArrayLit is the (elements) of an array assignment.
+type ArrayLit struct {
+	Elems []*Word
+}
+
+type IfClause struct {
+	Pos   Pos
+	Cond  *List
+	Then  *List
+	Elifs []*Elif
+	Else  *List
+	Text  string
+}
+
+type Elif struct {
+	Cond *List
+	Then *List
+}
+
+// WhileClause is a while loop, or an until loop when Until is set.
+type WhileClause struct {
+	Pos   Pos
+	Until bool
+	Cond  *List
+	Body  *List
+	Text  string
+}
+
+// ForClause iterates over Items, or over the positional parameters when
+// the loop has no "in" part.
+type ForClause struct {
+	Pos   Pos
+	Name  string
+	InSet bool
+	Items []*Word
+	Body  *List
+	Text  string
+}
+
+type CaseClause struct {
+	Pos   Pos
+	Word  *Word
+	Items []*CaseItem
+	Text  string
+}
+
+type CaseItem struct {
+	Patterns []*Word
+	Body     *List
+}
+
+// BraceGroup is a list run in the current shell, written { list; }.
+type BraceGroup struct {
+	Pos  Pos
+	List *List
+	Text string
+}
+
+// Subshell is a list run in a copy of the shell, written ( list ).
+type Subshell struct {
+	Pos  Pos
+	List *List
+	Text string
+}
+
+// Redirected is a compound command followed by redirections, which apply
+// to everything it runs.
+type Redirected struct {
+	Pos    Pos
+	Cmd    Command
+	Redirs []*Redirect
+	Text   string
+}
+
+// Coproc runs Cmd in the background with its standard input and output
+// connected to the shell through pipes, whose descriptors are left in
+// ${Name[1]} and ${Name[0]}.
+type Coproc struct {
+	Pos  Pos
+	Name string
+	Cmd  Command
+	Text string
+}
+
+// FuncDecl defines the function Name; Body is a compound command.
+type FuncDecl struct {
+	Pos  Pos
+	Name string
+	Body Command
+	Text string
+}
+
+func (*SimpleCommand) commandNode() {}
+func (*IfClause) commandNode()      {}
+func (*WhileClause) commandNode()   {}
+func (*ForClause) commandNode()     {}
+func (*CaseClause) commandNode()    {}
+func (*BraceGroup) commandNode()    {}
+func (*Subshell) commandNode()      {}
+func (*Redirected) commandNode()    {}
+func (*Coproc) commandNode()        {}
+func (*FuncDecl) commandNode()      {}
+
+// Redirect is an I/O redirection; Fd is -1 when no descriptor was written
+// before the operator. For a here-document Target is the delimiter and Doc
+// the body, read from the lines after the one holding the operator; Raw is
+// the body as written.
+type Redirect struct {
+	Pos    Pos
+	Fd     int
+	Op     string
+	Target *Word
+	Doc    *Word
+	Raw    string
+}
+
+type Word struct {
+	Pos   Pos
+	Parts []WordPart
+}
+
+type WordPart interface {
+	wordPart()
+}
+
+// Lit is unquoted text as written in the source, backslash escapes included.
+type Lit struct {
+	Value string
+}
+
+type SglQuoted struct {
+	Value string
+}
+
+type DblQuoted struct {
+	Parts []WordPart
+}
+
+// ParamExp is $name or ${name...}. Index is the subscript of
+// ${name[index]}, an arithmetic expression or @ or *. Length is set for
+// ${#name}. Op is one of the operators :- - := = :? ? :+ + # ## % %% / //
+// /# /%, applied with Arg; Repl is the replacement of the / operators.
+type ParamExp struct {
+	Name   string
+	Braced bool
+	Index  *Word
+	Length bool
+	Op     string
+	Arg    *Word
+	Repl   *Word
+}
+
+// CmdSubst is $(list), or `list` when Backquote is set.
+type CmdSubst struct {
+	List      *List
+	Backquote bool
+}
+
+// ProcSubst is <(list), or >(list) when Out is set: list runs with its
+// output, or input, connected to a pipe that the command is given as a
+// /dev/fd path. Text is the source of list.
+type ProcSubst struct {
+	List *List
+	Text string
+	Out  bool
+}
+
+// ArithExp is $((expr)); the expression may itself contain expansions.
+type ArithExp struct {
+	Parts []WordPart
+}
+
+func (*Lit) wordPart()       {}
+func (*SglQuoted) wordPart() {}
+func (*DblQuoted) wordPart() {}
+func (*ParamExp) wordPart()  {}
+func (*CmdSubst) wordPart()  {}
+func (*ArithExp) wordPart()  {}
+func (*ProcSubst) wordPart() {}
+
+type SyntaxError struct {
+	Pos        Pos
+	Msg        string
+	Incomplete bool
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
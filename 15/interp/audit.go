@@ -0,0 +1,148 @@
+package interp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// auditRecord is the line written to the audit log for each pipeline the
+// shell runs. Status is null for a pipeline left running in the background.
+type auditRecord struct {
+	Time       time.Time  `json:"time"`
+	Dir        string     `json:"cwd"`
+	Cmds       []auditCmd `json:"cmds"`
+	Background bool       `json:"background,omitempty"`
+	Status     *int       `json:"status"`
+	// Duration is in seconds.
+	Duration float64 `json:"duration"`
+}
+
+// auditCmd is one command of a pipeline, with its assignments and
+// arguments expanded. Pid is left out for a command the shell ran itself,
+// and Status is null for one still running or stopped.
+type auditCmd struct {
+	Assigns []string     `json:"assign,omitempty"`
+	Args    []string     `json:"argv"`
+	Redirs  []auditRedir `json:"redirs,omitempty"`
+	Pid     int          `json:"pid,omitempty"`
+	Status  *int         `json:"status"`
+}
+
+type auditRedir struct {
+	Fd     int    `json:"fd"`
+	Op     string `json:"op"`
+	Target string `json:"target"`
+}
+
+// auditLog is where the records go. Subshells share it with the shell, and
+// write to it from their own goroutines.
+type auditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// SetAudit makes the shell append a JSON line describing each pipeline it
+// runs to the file at path, or send it to the Unix socket there.
+func (r *Runner) SetAudit(path string) error {
+	w, err := openAudit(path)
+	if err != nil {
+		return err
+	}
+	r.audit = &auditLog{w: w}
+	return nil
+}
+
+// openAudit connects to the Unix socket at path, stream or datagram, or
+// opens the file there for appending.
+func openAudit(path string) (io.Writer, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		conn, err := net.Dial("unix", path)
+		if errors.Is(err, syscall.EPROTOTYPE) {
+			conn, err = net.Dial("unixgram", path)
+		}
+		return conn, err
+	}
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+}
+
+// newAudit starts the record of a pipeline, or returns nil when there is
+// no audit log.
+func (r *Runner) newAudit(background bool) *auditRecord {
+	if r.audit == nil {
+		return nil
+	}
+	return &auditRecord{Time: time.Now(), Dir: r.dir, Background: background}
+}
+
+func (rec *auditRecord) addCmd(c *Cmd) {
+	if rec == nil {
+		return
+	}
+	ac := auditCmd{Assigns: c.Env, Args: append([]string{}, c.Args...)}
+	for _, rd := range c.Redirs {
+		ac.Redirs = append(ac.Redirs, auditRedir{rd.Fd, rd.Op, rd.Target})
+	}
+	rec.Cmds = append(rec.Cmds, ac)
+}
+
+// ran records status for a pipeline of one command that the shell ran
+// itself, and returns it.
+func (rec *auditRecord) ran(status int) int {
+	if rec != nil {
+		rec.Cmds[0].Status = &status
+	}
+	return status
+}
+
+// addProcs records the pids and exit statuses of procs, which stand for
+// the commands of the pipeline in order.
+func (rec *auditRecord) addProcs(procs []*proc) {
+	if rec == nil {
+		return
+	}
+	for i, p := range procs {
+		if i == len(rec.Cmds) {
+			break
+		}
+		if p.result == nil {
+			rec.Cmds[i].Pid = p.pid
+		}
+		if p.done {
+			status := p.exitStatus()
+			rec.Cmds[i].Status = &status
+		}
+	}
+}
+
+// writeAudit finishes rec with the pipeline's status and writes it out in
+// a single write, so that lines from several shells sharing the log do not
+// interleave.
+func (r *Runner) writeAudit(rec *auditRecord, status int) {
+	if rec == nil {
+		return
+	}
+	rec.Duration = time.Since(rec.Time).Seconds()
+	if !rec.Background {
+		rec.Status = &status
+	}
+	var line bytes.Buffer
+	enc := json.NewEncoder(&line)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(rec)
+	if err == nil {
+		r.audit.mu.Lock()
+		_, err = r.audit.w.Write(line.Bytes())
+		r.audit.mu.Unlock()
+	}
+	if err != nil {
+		fmt.Fprintln(r.stderr, "audit:", err)
+	}
+}
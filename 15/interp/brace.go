@@ -0,0 +1,139 @@
+package interp
+
+import (
+	"strconv"
+	"strings"
+)
+
+// braceExpand expands the first {a,b} or {x..y} group of f and recurses on
+// the results. Only unquoted braces and commas written in the source count.
+func braceExpand(f field) []field {
+	for i, ch := range f {
+		if !isBraceMeta(ch, '{') {
+			continue
+		}
+		depth := 0
+		var commas []int
+		end := -1
+		for j := i + 1; j < len(f) && end < 0; j++ {
+			switch {
+			case isBraceMeta(f[j], '{'):
+				depth++
+			case isBraceMeta(f[j], '}'):
+				if depth == 0 {
+					end = j
+				}
+				depth--
+			case isBraceMeta(f[j], ',') && depth == 0:
+				commas = append(commas, j)
+			}
+		}
+		if end < 0 {
+			return []field{f}
+		}
+		var alts []field
+		if len(commas) > 0 {
+			start := i + 1
+			for _, c := range append(commas, end) {
+				alts = append(alts, f[start:c])
+				start = c + 1
+			}
+		} else if seq := braceSequence(f[i+1 : end].String()); seq != nil {
+			for _, s := range seq {
+				alts = append(alts, field{}.add(s, false, false))
+			}
+		} else {
+			continue
+		}
+		var out []field
+		for _, alt := range alts {
+			nf := make(field, 0, len(f))
+			nf = append(nf, f[:i]...)
+			nf = append(nf, alt...)
+			nf = append(nf, f[end+1:]...)
+			out = append(out, braceExpand(nf)...)
+		}
+		return out
+	}
+	return []field{f}
+}
+
+func isBraceMeta(ch fchar, c byte) bool {
+	return ch.c == c && ch.literal && !ch.quoted
+}
+
+// braceSequence expands the body of {x..y} or {x..y..step} for integers or
+// single letters; it returns nil if s is not a sequence.
+func braceSequence(s string) []string {
+	parts := strings.Split(s, "..")
+	if len(parts) != 2 && len(parts) != 3 {
+		return nil
+	}
+	step := 1
+	if len(parts) == 3 {
+		n, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return nil
+		}
+		if n < 0 {
+			n = -n
+		}
+		if n != 0 {
+			step = n
+		}
+	}
+	if lo, err := strconv.Atoi(parts[0]); err == nil {
+		hi, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil
+		}
+		width := 0
+		if zeroPadded(parts[0]) || zeroPadded(parts[1]) {
+			width = max(len(parts[0]), len(parts[1]))
+		}
+		var out []string
+		for _, n := range seqInts(lo, hi, step) {
+			v := strconv.Itoa(n)
+			if width > 0 && len(v) < width {
+				if n < 0 {
+					v = "-" + strings.Repeat("0", width-len(v)) + v[1:]
+				} else {
+					v = strings.Repeat("0", width-len(v)) + v
+				}
+			}
+			out = append(out, v)
+		}
+		return out
+	}
+	if len(parts[0]) == 1 && len(parts[1]) == 1 && isLetter(parts[0][0]) && isLetter(parts[1][0]) {
+		var out []string
+		for _, n := range seqInts(int(parts[0][0]), int(parts[1][0]), step) {
+			out = append(out, string(rune(n)))
+		}
+		return out
+	}
+	return nil
+}
+
+func seqInts(lo, hi, step int) []int {
+	var out []int
+	if lo <= hi {
+		for n := lo; n <= hi; n += step {
+			out = append(out, n)
+		}
+	} else {
+		for n := lo; n >= hi; n -= step {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+func zeroPadded(s string) bool {
+	s = strings.TrimPrefix(s, "-")
+	return len(s) > 1 && s[0] == '0'
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
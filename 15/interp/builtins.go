@@ -0,0 +1,329 @@
+package interp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+func (r *Runner) builtinExit(cmd *Cmd) int {
+	status := r.lastStatus
+	if len(cmd.Args) > 1 {
+		n, err := strconv.Atoi(cmd.Args[1])
+		if err != nil {
+			fmt.Fprintf(cmd.Stderr, "exit: %s: numeric argument required\n", cmd.Args[1])
+			n = 2
+		}
+		status = n & 0xff
+	}
+	r.exiting, r.exitCode = true, status
+	return status
+}
+
+// builtinRead reads a line from standard input and splits it on IFS into
+// the named variables, the last of which takes the rest of the line; REPLY
+// gets the whole line when no names are given. Without -r a backslash
+// quotes the next character and joins a line to the next. -p prints a
+// prompt on standard error when reading from a terminal.
+func (r *Runner) builtinRead(cmd *Cmd) int {
+	args := cmd.Args[1:]
+	raw := false
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") && args[0] != "-" {
+		opt := args[0]
+		args = args[1:]
+		if opt == "--" {
+			break
+		}
+		for i := 1; i < len(opt); i++ {
+			switch opt[i] {
+			case 'r':
+				raw = true
+			case 'p':
+				prompt := opt[i+1:]
+				if prompt == "" {
+					if len(args) == 0 {
+						fmt.Fprintln(cmd.Stderr, "read: -p: option requires an argument")
+						return 2
+					}
+					prompt, args = args[0], args[1:]
+				}
+				if f, ok := cmd.Stdin.(*os.File); ok && isTerminal(int(f.Fd())) {
+					fmt.Fprint(cmd.Stderr, prompt)
+				}
+				i = len(opt)
+			default:
+				fmt.Fprintf(cmd.Stderr, "read: -%c: invalid option\n", opt[i])
+				fmt.Fprintln(cmd.Stderr, "read: usage: read [-r] [-p prompt] [name ...]")
+				return 2
+			}
+		}
+	}
+	for _, name := range args {
+		if !isName(name) {
+			fmt.Fprintf(cmd.Stderr, "read: `%s': not a valid identifier\n", name)
+			return 1
+		}
+	}
+
+	// Read a byte at a time so that nothing past the newline is taken from
+	// a descriptor the next command will read. quoted marks the bytes a
+	// backslash protected from splitting.
+	var line []byte
+	var quoted []bool
+	status := 0
+	b := make([]byte, 1)
+	for {
+		n, err := cmd.Stdin.Read(b)
+		if n == 0 {
+			if err == nil {
+				continue
+			}
+			if !errors.Is(err, io.EOF) {
+				fmt.Fprintln(cmd.Stderr, "read:", err)
+			}
+			status = 1
+			break
+		}
+		if b[0] == '\n' {
+			break
+		}
+		if b[0] == '\\' && !raw {
+			if n, _ := cmd.Stdin.Read(b); n == 0 {
+				status = 1
+				break
+			}
+			if b[0] != '\n' {
+				line = append(line, b[0])
+				quoted = append(quoted, true)
+			}
+			continue
+		}
+		line = append(line, b[0])
+		quoted = append(quoted, false)
+	}
+
+	if len(args) == 0 {
+		r.setVar("REPLY", string(line))
+		return status
+	}
+	ifs, ok := r.getVar("IFS")
+	if !ok {
+		ifs = " \t\n"
+	}
+	fields := splitRead(line, quoted, ifs, len(args))
+	for i, name := range args {
+		value := ""
+		if i < len(fields) {
+			value = fields[i]
+		}
+		r.setVar(name, value)
+	}
+	return status
+}
+
+// splitRead splits line into at most n fields at the unquoted bytes in
+// ifs. IFS white space around a field is dropped and runs of it count as a
+// single separator; the last field keeps the rest of the line, separators
+// included, less trailing IFS white space.
+func splitRead(line []byte, quoted []bool, ifs string, n int) []string {
+	isSep := func(i int) bool { return !quoted[i] && strings.IndexByte(ifs, line[i]) >= 0 }
+	isSpace := func(i int) bool { return isSep(i) && strings.IndexByte(" \t\n", line[i]) >= 0 }
+	end := len(line)
+	for end > 0 && isSpace(end-1) {
+		end--
+	}
+	var fields []string
+	i := 0
+	for i < end && isSpace(i) {
+		i++
+	}
+	for i < end {
+		if len(fields) == n-1 {
+			fields = append(fields, string(line[i:end]))
+			break
+		}
+		start := i
+		for i < end && !isSep(i) {
+			i++
+		}
+		fields = append(fields, string(line[start:i]))
+		// Skip the separator: surrounding white space plus at most one
+		// other IFS character.
+		for i < end && isSpace(i) {
+			i++
+		}
+		if i < end && isSep(i) {
+			i++
+			for i < end && isSpace(i) {
+				i++
+			}
+		}
+	}
+	return fields
+}
+
+func isTerminal(fd int) bool {
+	_, err := tcgetattr(fd)
+	return err == nil
+}
+
+// builtinType says what each name would run as: an alias, keyword,
+// function, builtin or file. -t prints just the kind.
+func (r *Runner) builtinType(cmd *Cmd) int {
+	args := cmd.Args[1:]
+	kindOnly := len(args) > 0 && args[0] == "-t"
+	if kindOnly {
+		args = args[1:]
+	}
+	status := 0
+	for _, name := range args {
+		kind, detail := r.commandKind(name)
+		switch {
+		case kind == "":
+			if !kindOnly {
+				fmt.Fprintf(cmd.Stderr, "type: %s: not found\n", name)
+			}
+			status = 1
+		case kindOnly:
+			fmt.Fprintln(cmd.Stdout, kind)
+		case kind == "alias":
+			fmt.Fprintf(cmd.Stdout, "%s is aliased to `%s'\n", name, detail)
+		case kind == "keyword":
+			fmt.Fprintf(cmd.Stdout, "%s is a shell keyword\n", name)
+		case kind == "function":
+			fmt.Fprintf(cmd.Stdout, "%s is a function\n%s\n", name, detail)
+		case kind == "builtin":
+			fmt.Fprintf(cmd.Stdout, "%s is a shell builtin\n", name)
+		default:
+			fmt.Fprintf(cmd.Stdout, "%s is %s\n", name, detail)
+		}
+	}
+	return status
+}
+
+// builtinWhich prints the path of each command, or says that it is an
+// alias, function or builtin. -a lists every match in PATH.
+func (r *Runner) builtinWhich(cmd *Cmd) int {
+	args := cmd.Args[1:]
+	all := len(args) > 0 && args[0] == "-a"
+	if all {
+		args = args[1:]
+	}
+	status := 0
+	for _, name := range args {
+		kind, detail := r.commandKind(name)
+		switch kind {
+		case "alias":
+			fmt.Fprintf(cmd.Stdout, "%s: aliased to %s\n", name, detail)
+		case "keyword":
+			fmt.Fprintf(cmd.Stdout, "%s: shell reserved word\n", name)
+		case "function":
+			fmt.Fprintf(cmd.Stdout, "%s: shell function\n", name)
+		case "builtin":
+			fmt.Fprintf(cmd.Stdout, "%s: shell builtin\n", name)
+		}
+		if kind != "" && kind != "file" && !all {
+			continue
+		}
+		paths := r.lookPathAll(name)
+		if len(paths) == 0 {
+			if kind == "" {
+				path, _ := r.getVar("PATH")
+				fmt.Fprintf(cmd.Stderr, "which: no %s in (%s)\n", name, path)
+				status = 1
+			}
+			continue
+		}
+		if !all {
+			paths = paths[:1]
+		}
+		for _, p := range paths {
+			fmt.Fprintln(cmd.Stdout, p)
+		}
+	}
+	return status
+}
+
+// commandKind reports what name refers to as a command, in the order the
+// shell looks: "alias", "keyword", "function", "builtin" or "file", with
+// the alias value, function text or file path as detail. It returns ""
+// when name is none of these.
+func (r *Runner) commandKind(name string) (kind, detail string) {
+	if v, ok := r.aliases[name]; ok {
+		return "alias", v
+	}
+	if isReserved(name) {
+		return "keyword", ""
+	}
+	if f, ok := r.functions[name]; ok {
+		return "function", f.Text
+	}
+	if isBuiltin([]string{name}) {
+		return "builtin", ""
+	}
+	if path, err := r.lookPath(name, nil); err == nil {
+		if fi, err := os.Stat(r.path(path)); err == nil && !fi.IsDir() && fi.Mode()&0111 != 0 {
+			return "file", path
+		}
+	}
+	return "", ""
+}
+
+// lookPathAll returns every executable called name in PATH.
+func (r *Runner) lookPathAll(name string) []string {
+	if strings.Contains(name, "/") {
+		if fi, err := os.Stat(r.path(name)); err == nil && !fi.IsDir() && fi.Mode()&0111 != 0 {
+			return []string{name}
+		}
+		return nil
+	}
+	path, _ := r.getVar("PATH")
+	var out []string
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+		p := filepath.Join(dir, name)
+		if fi, err := os.Stat(r.path(p)); err == nil && !fi.IsDir() && fi.Mode()&0111 != 0 {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// errWriter passes writes on to w until one fails, and then keeps the
+// error and writes nothing more.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *errWriter) Write(b []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if f, ok := w.w.(*os.File); ok && f == nil {
+		// The descriptor was closed.
+		w.err = syscall.EBADF
+		return 0, w.err
+	}
+	n, err := w.w.Write(b)
+	w.err = err
+	return n, err
+}
+
+// errorDescription describes err as the shell reports it: for a system
+// error, the capitalized message of its errno.
+func errorDescription(err error) string {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return err.Error()
+	}
+	msg := errno.Error()
+	return strings.ToUpper(msg[:1]) + msg[1:]
+}
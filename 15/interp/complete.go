@@ -0,0 +1,153 @@
+package interp
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// isCompletionBreak reports whether r ends the word being completed.
+func isCompletionBreak(r rune) bool {
+	return r == ' ' || r == '\t' || r < utf8.RuneSelf && isOpStart(byte(r))
+}
+
+// completions returns the candidates for word, given the text of the line
+// before it: variable names after '$', commands in command position and
+// file names otherwise.
+func (r *Runner) completions(before, word string) []string {
+	switch {
+	case strings.HasPrefix(word, "${"):
+		return r.varCompletions(word[2:], "${", "}")
+	case strings.HasPrefix(word, "$"):
+		return r.varCompletions(word[1:], "$", "")
+	case !strings.Contains(word, "/") && commandPosition(before):
+		return r.commandCompletions(word)
+	}
+	return r.fileCompletions(word)
+}
+
+// commandPosition reports whether a word following before would be the name
+// of a command.
+func commandPosition(before string) bool {
+	before = strings.TrimRight(before, " \t")
+	if before == "" {
+		return true
+	}
+	if strings.IndexByte(";|&(`\n", before[len(before)-1]) >= 0 {
+		return true
+	}
+	prev := before[strings.LastIndexAny(before, " \t")+1:]
+	switch prev {
+	case "if", "then", "elif", "else", "while", "until", "do", "!", "{":
+		return true
+	}
+	return false
+}
+
+func (r *Runner) varCompletions(prefix, open, close string) []string {
+	var out []string
+	for name := range r.vars {
+		if strings.HasPrefix(name, prefix) {
+			out = append(out, open+name+close)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (r *Runner) commandCompletions(prefix string) []string {
+	seen := map[string]bool{}
+	for _, name := range builtinNames {
+		if strings.HasPrefix(name, prefix) {
+			seen[name] = true
+		}
+	}
+	for name := range r.functions {
+		if strings.HasPrefix(name, prefix) {
+			seen[name] = true
+		}
+	}
+	for name := range r.aliases {
+		if strings.HasPrefix(name, prefix) {
+			seen[name] = true
+		}
+	}
+	path, _ := r.getVar("PATH")
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+		entries, err := os.ReadDir(r.path(dir))
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			name := e.Name()
+			if !strings.HasPrefix(name, prefix) || seen[name] {
+				continue
+			}
+			info, err := os.Stat(r.path(filepath.Join(dir, name)))
+			if err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+				continue
+			}
+			seen[name] = true
+		}
+	}
+	out := make([]string, 0, len(seen))
+	for name := range seen {
+		out = append(out, escapeWord(name))
+	}
+	sort.Strings(out)
+	return out
+}
+
+// fileCompletions lists the paths that start with word, escaped so they
+// read back as one word, with a slash after directories.
+func (r *Runner) fileCompletions(word string) []string {
+	word = strings.NewReplacer(`"`, "", `'`, "").Replace(unescape(word, ""))
+	dir, base := "", word
+	if i := strings.LastIndexByte(word, '/'); i >= 0 {
+		dir, base = word[:i+1], word[i+1:]
+	}
+	list := dir
+	if strings.HasPrefix(list, "~/") {
+		home, _ := r.getVar("HOME")
+		list = home + list[1:]
+	}
+	if list == "" {
+		list = "."
+	}
+	entries, err := os.ReadDir(r.path(list))
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, base) || name[0] == '.' && !strings.HasPrefix(base, ".") {
+			continue
+		}
+		cand := escapeWord(dir + name)
+		if info, err := os.Stat(r.path(filepath.Join(list, name))); err == nil && info.IsDir() {
+			cand += "/"
+		}
+		out = append(out, cand)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// escapeWord backslash-escapes the characters of s that the shell would
+// otherwise treat specially.
+func escapeWord(s string) string {
+	out := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(" \t\n\\'\"$`&|;<>()*?[]{}!#", s[i]) >= 0 {
+			out.WriteByte('\\')
+		}
+		out.WriteByte(s[i])
+	}
+	return out.String()
+}
@@ -0,0 +1,256 @@
+package interp
+
+import (
+	"fmt"
+	"os/signal"
+	"strconv"
+)
+
+func (r *Runner) interrupted() bool {
+	return r.breakN > 0 || r.continueN > 0 || r.returning || r.interruptReq || r.exiting
+}
+
+func (r *Runner) runCompound(c Command) int {
+	switch c := c.(type) {
+	case *IfClause:
+		return r.runIf(c)
+	case *WhileClause:
+		return r.runWhile(c)
+	case *ForClause:
+		return r.runFor(c)
+	case *CaseClause:
+		return r.runCase(c)
+	case *BraceGroup:
+		return r.runList(c.List)
+	case *Subshell:
+		return r.inSubshell(func() int {
+			return r.runList(c.List)
+		})
+	case *Redirected:
+		return r.runRedirected(c)
+	case *FuncDecl:
+		return r.defineFunction(c)
+	case *Coproc:
+		return r.runCoproc(c)
+	}
+	return 0
+}
+
+func (r *Runner) runRedirected(c *Redirected) int {
+	var redirs []Redir
+	for _, redir := range c.Redirs {
+		rd, err := r.expandRedir(redir)
+		if err != nil {
+			fmt.Fprintln(r.stderr, err)
+			return 1
+		}
+		redirs = append(redirs, rd)
+	}
+	return r.withRedirs(redirs, func() int {
+		return r.runCompound(c.Cmd)
+	})
+}
+
+func commandPos(c Command) Pos {
+	switch c := c.(type) {
+	case *SimpleCommand:
+		return c.Pos
+	case *IfClause:
+		return c.Pos
+	case *WhileClause:
+		return c.Pos
+	case *ForClause:
+		return c.Pos
+	case *CaseClause:
+		return c.Pos
+	case *BraceGroup:
+		return c.Pos
+	case *Subshell:
+		return c.Pos
+	case *Redirected:
+		return c.Pos
+	case *FuncDecl:
+		return c.Pos
+	case *Coproc:
+		return c.Pos
+	}
+	return Pos{}
+}
+
+func (r *Runner) runIf(c *IfClause) int {
+	if r.runCond(c.Cond) == 0 {
+		return r.runList(c.Then)
+	}
+	for _, e := range c.Elifs {
+		if r.runCond(e.Cond) == 0 {
+			return r.runList(e.Then)
+		}
+	}
+	if c.Else != nil {
+		return r.runList(c.Else)
+	}
+	return 0
+}
+
+// runCond runs the condition of an if, while or until, where a failure
+// does not trip set -e.
+func (r *Runner) runCond(l *List) int {
+	r.errexitIgnored++
+	defer func() { r.errexitIgnored-- }()
+	return r.runList(l)
+}
+
+// loopStep consumes one level of a pending break or continue and reports
+// whether the current loop has to stop.
+func (r *Runner) loopStep() bool {
+	if r.returning || r.interruptReq || r.exiting {
+		return true
+	}
+	if r.breakN > 0 {
+		r.breakN--
+		return true
+	}
+	if r.continueN > 0 {
+		r.continueN--
+		return r.continueN > 0
+	}
+	return false
+}
+
+func (r *Runner) runWhile(c *WhileClause) int {
+	r.loopDepth++
+	defer func() { r.loopDepth-- }()
+	status := 0
+	for {
+		cond := r.runCond(c.Cond)
+		if r.interrupted() {
+			if r.loopStep() {
+				break
+			}
+			continue
+		}
+		if (cond == 0) == c.Until {
+			break
+		}
+		status = r.runList(c.Body)
+		if r.loopStep() {
+			break
+		}
+	}
+	return status
+}
+
+func (r *Runner) runFor(c *ForClause) int {
+	r.loopDepth++
+	defer func() { r.loopDepth-- }()
+	var items []string
+	if c.InSet {
+		for _, w := range c.Items {
+			fields, err := r.expandFields(w)
+			if err != nil {
+				fmt.Fprintln(r.stderr, err)
+				return 1
+			}
+			items = append(items, fields...)
+		}
+	} else {
+		items = append(items, r.positional...)
+	}
+	status := 0
+	for _, item := range items {
+		r.setVar(c.Name, item)
+		status = r.runList(c.Body)
+		if r.loopStep() {
+			break
+		}
+	}
+	return status
+}
+
+func (r *Runner) runCase(c *CaseClause) int {
+	word, err := r.expandWord(c.Word)
+	if err != nil {
+		fmt.Fprintln(r.stderr, err)
+		return 1
+	}
+	for _, item := range c.Items {
+		for _, pat := range item.Patterns {
+			pattern, err := r.expandPattern(pat)
+			if err != nil {
+				fmt.Fprintln(r.stderr, err)
+				return 1
+			}
+			if matchPattern(pattern, word) {
+				return r.runList(item.Body)
+			}
+		}
+	}
+	return 0
+}
+
+func (r *Runner) builtinLoopControl(cmd *Cmd) int {
+	name := cmd.Args[0]
+	if r.loopDepth == 0 {
+		fmt.Fprintf(cmd.Stderr, "%s: only meaningful in a loop\n", name)
+		return 0
+	}
+	n := 1
+	if len(cmd.Args) > 1 {
+		v, err := strconv.Atoi(cmd.Args[1])
+		if err != nil || v < 1 {
+			fmt.Fprintf(cmd.Stderr, "%s: %s: loop count out of range\n", name, cmd.Args[1])
+			return 1
+		}
+		n = v
+	}
+	if n > r.loopDepth {
+		n = r.loopDepth
+	}
+	if name == "break" {
+		r.breakN = n
+	} else {
+		r.continueN = n
+	}
+	return 0
+}
+
+// inSubshell runs fn with the variables, functions, aliases, positional
+// parameters, working directory, directory stack, options, traps, resource
+// limits and loop state restored afterwards, so changes made by fn do not
+// leak into the calling shell. An EXIT trap set by fn runs when fn returns.
+func (r *Runner) inSubshell(fn func() int) int {
+	savedVars := cloneVars(r.vars)
+	savedFuncs := make(map[string]*FuncDecl, len(r.functions))
+	for name, f := range r.functions {
+		savedFuncs[name] = f
+	}
+	savedAliases := make(map[string]string, len(r.aliases))
+	for name, a := range r.aliases {
+		savedAliases[name] = a
+	}
+	savedPositional, savedName := r.positional, r.scriptName
+	savedStack := append([]string(nil), r.dirStack...)
+	savedOpts, savedTraps := r.optionValues(), r.traps
+	r.setTraps(subshellTraps(savedTraps))
+	savedStatus, savedDepth := r.lastStatus, r.loopDepth
+	savedDir := r.dir
+	savedLimits := r.limits
+	r.loopDepth = 0
+
+	status := r.exitStatus(fn())
+	for sig := range r.traps {
+		if _, ok := savedTraps[sig]; !ok && r.sigc != nil && sig != 0 && !r.shellCatches(sig) {
+			signal.Reset(sig)
+		}
+	}
+	r.setTraps(savedTraps)
+	r.setOptionValues(savedOpts)
+
+	r.vars, r.functions, r.aliases = savedVars, savedFuncs, savedAliases
+	r.positional, r.scriptName, r.dirStack = savedPositional, savedName, savedStack
+	r.lastStatus, r.loopDepth = savedStatus, savedDepth
+	r.breakN, r.continueN, r.returning, r.exiting = 0, 0, false, false
+	r.dir = savedDir
+	r.limits = savedLimits
+	return status
+}
@@ -0,0 +1,372 @@
+package interp
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// initPwd keeps the PWD inherited from the environment when it names the
+// working directory, possibly through symbolic links, and resets it to the
+// physical path otherwise.
+func (r *Runner) initPwd() {
+	if pwd, ok := r.getVar("PWD"); ok && filepath.IsAbs(pwd) && sameDir(pwd, r.dir) {
+		r.dir = filepath.Clean(pwd)
+		return
+	}
+	if wd, err := filepath.EvalSymlinks(r.dir); err == nil {
+		r.dir = wd
+	}
+	r.setExported("PWD", r.dir)
+}
+
+func (r *Runner) setExported(name, value string) {
+	r.vars[name] = &variable{value: value, exported: true}
+}
+
+func sameDir(a, b string) bool {
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	fb, err := os.Stat(b)
+	return err == nil && os.SameFile(fa, fb)
+}
+
+// changeDir makes dir the working directory and updates PWD and OLDPWD.
+// A logical change resolves .. against PWD, so that leaving a directory
+// reached through a symbolic link goes back the way it came; a physical one
+// resolves every link and sets PWD to the real path.
+func (r *Runner) changeDir(dir string, physical bool) error {
+	old, ok := r.getVar("PWD")
+	if !ok || old == "" {
+		old = r.dir
+	}
+	pwd := ""
+	if !physical {
+		logical := dir
+		if !filepath.IsAbs(dir) {
+			logical = filepath.Join(old, dir)
+		}
+		logical = filepath.Clean(logical)
+		if isSearchableDir(logical) == nil {
+			pwd = logical
+		}
+	}
+	if pwd == "" {
+		path := r.path(dir)
+		err := isSearchableDir(path)
+		if err == nil {
+			path, err = filepath.EvalSymlinks(path)
+		}
+		if err != nil {
+			var pe *os.PathError
+			if errors.As(err, &pe) {
+				err = pe.Err
+			}
+			return fmt.Errorf("%s: %v", dir, err)
+		}
+		pwd = path
+	}
+	r.dir = pwd
+	r.setExported("OLDPWD", old)
+	r.setExported("PWD", pwd)
+	return nil
+}
+
+// isSearchableDir checks that path is a directory that can be entered, as
+// chdir would.
+func isSearchableDir(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return &os.PathError{Op: "chdir", Path: path, Err: syscall.ENOTDIR}
+	}
+	if err := syscall.Access(path, 1); err != nil {
+		return &os.PathError{Op: "chdir", Path: path, Err: err}
+	}
+	return nil
+}
+
+// cdTarget looks a relative directory up in CDPATH. found reports that it
+// came from a CDPATH entry other than the current directory, in which case
+// cd prints where it went.
+func (r *Runner) cdTarget(dir string) (target string, found bool) {
+	cdpath, _ := r.getVar("CDPATH")
+	if cdpath == "" || filepath.IsAbs(dir) || dir == "." || dir == ".." ||
+		strings.HasPrefix(dir, "./") || strings.HasPrefix(dir, "../") {
+		return dir, false
+	}
+	for _, entry := range filepath.SplitList(cdpath) {
+		if entry == "" || entry == "." {
+			if fi, err := os.Stat(r.path(dir)); err == nil && fi.IsDir() {
+				return dir, false
+			}
+			continue
+		}
+		cand := filepath.Join(entry, dir)
+		if fi, err := os.Stat(r.path(cand)); err == nil && fi.IsDir() {
+			return cand, true
+		}
+	}
+	return dir, false
+}
+
+// builtinCd changes to the directory given, to $HOME without one, or to
+// $OLDPWD for "-". -P resolves symbolic links; -L, the default, does not.
+func (r *Runner) builtinCd(cmd *Cmd) int {
+	args := cmd.Args[1:]
+	physical := false
+opts:
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		switch args[0] {
+		case "-L":
+			physical = false
+		case "-P":
+			physical = true
+		case "--":
+			args = args[1:]
+			break opts
+		default:
+			fmt.Fprintf(cmd.Stderr, "cd: %s: invalid option\n", args[0])
+			fmt.Fprintln(cmd.Stderr, "cd: usage: cd [-L|-P] [dir]")
+			return 2
+		}
+		args = args[1:]
+	}
+	if len(args) > 1 {
+		fmt.Fprintln(cmd.Stderr, "cd: too many arguments")
+		return 1
+	}
+	var dir string
+	show := false
+	switch {
+	case len(args) == 0:
+		home, ok := r.getVar("HOME")
+		if !ok || home == "" {
+			fmt.Fprintln(cmd.Stderr, "cd: HOME not set")
+			return 1
+		}
+		dir = home
+	case args[0] == "-":
+		old, ok := r.getVar("OLDPWD")
+		if !ok || old == "" {
+			fmt.Fprintln(cmd.Stderr, "cd: OLDPWD not set")
+			return 1
+		}
+		dir, show = old, true
+	default:
+		var found bool
+		dir, found = r.cdTarget(args[0])
+		show = found
+	}
+	if err := r.changeDir(dir, physical); err != nil {
+		fmt.Fprintln(cmd.Stderr, "cd:", err)
+		return 1
+	}
+	if show {
+		pwd, _ := r.getVar("PWD")
+		fmt.Fprintln(cmd.Stdout, pwd)
+	}
+	return 0
+}
+
+// builtinPwd prints $PWD, or with -P the path with symbolic links resolved.
+func (r *Runner) builtinPwd(cmd *Cmd) int {
+	physical := false
+	for _, arg := range cmd.Args[1:] {
+		switch arg {
+		case "-L":
+			physical = false
+		case "-P":
+			physical = true
+		default:
+			fmt.Fprintf(cmd.Stderr, "pwd: %s: invalid option\n", arg)
+			return 2
+		}
+	}
+	pwd, ok := r.getVar("PWD")
+	if physical || !ok || !filepath.IsAbs(pwd) || !sameDir(pwd, r.dir) {
+		wd, err := filepath.EvalSymlinks(r.dir)
+		if err != nil {
+			fmt.Fprintln(cmd.Stderr, "pwd:", err)
+			return 1
+		}
+		pwd = wd
+	}
+	fmt.Fprintln(cmd.Stdout, pwd)
+	return 0
+}
+
+// dirList returns the directory stack with the current directory on top.
+func (r *Runner) dirList() []string {
+	pwd, _ := r.getVar("PWD")
+	return append([]string{pwd}, r.dirStack...)
+}
+
+// stackIndex converts +N, counting from the top of the list dirs prints,
+// or -N, counting from the bottom, into an index of dirList.
+func (r *Runner) stackIndex(arg string) (int, bool) {
+	n, err := strconv.Atoi(arg[1:])
+	size := len(r.dirStack) + 1
+	if err != nil || n < 0 || n >= size {
+		return 0, false
+	}
+	if arg[0] == '-' {
+		n = size - 1 - n
+	}
+	return n, true
+}
+
+func isStackArg(arg string) bool {
+	return len(arg) > 1 && (arg[0] == '+' || arg[0] == '-') && arg[1] >= '0' && arg[1] <= '9'
+}
+
+// builtinPushd saves the current directory on the stack and changes to dir.
+// Without arguments it swaps the top two directories; +N and -N rotate the
+// stack to bring that entry to the top.
+func (r *Runner) builtinPushd(cmd *Cmd) int {
+	args := cmd.Args[1:]
+	switch {
+	case len(args) > 1:
+		fmt.Fprintln(cmd.Stderr, "pushd: too many arguments")
+		return 1
+	case len(args) == 0:
+		if len(r.dirStack) == 0 {
+			fmt.Fprintln(cmd.Stderr, "pushd: no other directory")
+			return 1
+		}
+		list := r.dirList()
+		if err := r.changeDir(list[1], false); err != nil {
+			fmt.Fprintln(cmd.Stderr, "pushd:", err)
+			return 1
+		}
+		r.dirStack[0] = list[0]
+	case isStackArg(args[0]):
+		n, ok := r.stackIndex(args[0])
+		if !ok {
+			fmt.Fprintf(cmd.Stderr, "pushd: %s: directory stack index out of range\n", args[0])
+			return 1
+		}
+		list := r.dirList()
+		list = append(list[n:], list[:n]...)
+		if err := r.changeDir(list[0], false); err != nil {
+			fmt.Fprintln(cmd.Stderr, "pushd:", err)
+			return 1
+		}
+		r.dirStack = list[1:]
+	default:
+		old, _ := r.getVar("PWD")
+		dir, _ := r.cdTarget(args[0])
+		if err := r.changeDir(dir, false); err != nil {
+			fmt.Fprintln(cmd.Stderr, "pushd:", err)
+			return 1
+		}
+		r.dirStack = append([]string{old}, r.dirStack...)
+	}
+	r.printDirs(cmd, r.dirList(), false, false, false)
+	return 0
+}
+
+// builtinPopd drops the top of the stack and changes to the new top, or
+// with +N or -N drops that entry alone.
+func (r *Runner) builtinPopd(cmd *Cmd) int {
+	args := cmd.Args[1:]
+	if len(args) > 1 {
+		fmt.Fprintln(cmd.Stderr, "popd: too many arguments")
+		return 1
+	}
+	if len(r.dirStack) == 0 {
+		fmt.Fprintln(cmd.Stderr, "popd: directory stack empty")
+		return 1
+	}
+	n := 0
+	if len(args) == 1 {
+		var ok bool
+		if !isStackArg(args[0]) {
+			fmt.Fprintf(cmd.Stderr, "popd: %s: invalid argument\n", args[0])
+			return 1
+		}
+		if n, ok = r.stackIndex(args[0]); !ok {
+			fmt.Fprintf(cmd.Stderr, "popd: %s: directory stack index out of range\n", args[0])
+			return 1
+		}
+	}
+	if n == 0 {
+		if err := r.changeDir(r.dirStack[0], false); err != nil {
+			fmt.Fprintln(cmd.Stderr, "popd:", err)
+			return 1
+		}
+		r.dirStack = r.dirStack[1:]
+	} else {
+		r.dirStack = append(r.dirStack[:n-1], r.dirStack[n:]...)
+	}
+	r.printDirs(cmd, r.dirList(), false, false, false)
+	return 0
+}
+
+// builtinDirs prints the directory stack. -c clears it, -l prints full
+// paths instead of abbreviating $HOME to ~, -p prints one entry per line
+// and -v numbers them; +N and -N print a single entry.
+func (r *Runner) builtinDirs(cmd *Cmd) int {
+	long, perLine, numbered := false, false, false
+	list := r.dirList()
+	for _, arg := range cmd.Args[1:] {
+		if isStackArg(arg) {
+			n, ok := r.stackIndex(arg)
+			if !ok {
+				fmt.Fprintf(cmd.Stderr, "dirs: %s: directory stack index out of range\n", arg)
+				return 1
+			}
+			list = list[n : n+1]
+			continue
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			fmt.Fprintf(cmd.Stderr, "dirs: %s: invalid argument\n", arg)
+			return 1
+		}
+		for _, c := range arg[1:] {
+			switch c {
+			case 'c':
+				r.dirStack = nil
+				return 0
+			case 'l':
+				long = true
+			case 'p':
+				perLine = true
+			case 'v':
+				perLine, numbered = true, true
+			default:
+				fmt.Fprintf(cmd.Stderr, "dirs: -%c: invalid option\n", c)
+				fmt.Fprintln(cmd.Stderr, "dirs: usage: dirs [-clpv] [+N] [-N]")
+				return 2
+			}
+		}
+	}
+	r.printDirs(cmd, list, long, perLine, numbered)
+	return 0
+}
+
+func (r *Runner) printDirs(cmd *Cmd, list []string, long, perLine, numbered bool) {
+	shown := make([]string, len(list))
+	for i, dir := range list {
+		if !long {
+			dir = r.tildeDir(dir)
+		}
+		shown[i] = dir
+		if numbered {
+			shown[i] = fmt.Sprintf("%2d  %s", i, dir)
+		}
+	}
+	sep := " "
+	if perLine {
+		sep = "\n"
+	}
+	fmt.Fprintln(cmd.Stdout, strings.Join(shown, sep))
+}
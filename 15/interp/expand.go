@@ -0,0 +1,808 @@
+package interp
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+func (r *Runner) expandCmd(sc *SimpleCommand) (*Cmd, error) {
+	cmd := &Cmd{}
+	for _, a := range sc.Assigns {
+		if a.Array != nil || a.Index != nil {
+			aa, err := r.expandArrayAssign(a)
+			if err != nil {
+				return nil, err
+			}
+			cmd.arrays = append(cmd.arrays, aa)
+			continue
+		}
+		v, err := r.expandAssign(a.Value)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Env = append(cmd.Env, a.Name+"="+v)
+	}
+	// The NAME=value arguments of export and local are expanded like
+	// assignments, without field splitting.
+	decl := len(sc.Args) > 0 && (wordIsLit(sc.Args[0], "export") || wordIsLit(sc.Args[0], "local"))
+	for i, w := range sc.Args {
+		if a := splitAssign(w); decl && i > 0 && a != nil && a.Index == nil {
+			v, err := r.expandAssign(a.Value)
+			if err != nil {
+				return nil, err
+			}
+			cmd.Args = append(cmd.Args, a.Name+"="+v)
+			continue
+		}
+		args, err := r.expandFields(w)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Args = append(cmd.Args, args...)
+	}
+	for _, redir := range sc.Redirs {
+		rd, err := r.expandRedir(redir)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Redirs = append(cmd.Redirs, rd)
+	}
+	return cmd, nil
+}
+
+// expandArrayAssign expands NAME=(elements), whose elements are split and
+// globbed like command arguments, or NAME[index]=value.
+func (r *Runner) expandArrayAssign(a *Assign) (arrayAssign, error) {
+	aa := arrayAssign{name: a.Name, whole: a.Array != nil}
+	if a.Array != nil {
+		for _, w := range a.Array.Elems {
+			fields, err := r.expandFields(w)
+			if err != nil {
+				return aa, err
+			}
+			aa.values = append(aa.values, fields...)
+		}
+		return aa, nil
+	}
+	n, err := r.evalArithParts(a.Index.Parts)
+	if err != nil {
+		return aa, err
+	}
+	v, err := r.expandAssign(a.Value)
+	if err != nil {
+		return aa, err
+	}
+	aa.index, aa.values = int(n), []string{v}
+	return aa, nil
+}
+
+func (r *Runner) expandWord(w *Word) (string, error) {
+	f, err := r.expandField(w, false)
+	return f.String(), err
+}
+
+// expandPattern expands w into a glob pattern; characters that came from
+// quoted text are escaped so they only match themselves.
+func (r *Runner) expandPattern(w *Word) (string, error) {
+	f, err := r.expandField(w, false)
+	return f.pattern(), err
+}
+
+// expandAssign expands the value of NAME=value, where a tilde is also
+// recognised after every unquoted colon.
+func (r *Runner) expandAssign(w *Word) (string, error) {
+	f, err := r.expandField(w, true)
+	return f.String(), err
+}
+
+// expandFields expands a command argument into the words it stands for,
+// applying field splitting, brace expansion and then pathname expansion.
+func (r *Runner) expandFields(w *Word) ([]string, error) {
+	e := &expansion{split: true, ifs: r.ifs()}
+	if err := r.expandInto(e, w, false); err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, f := range e.finish() {
+		for _, bf := range braceExpand(f) {
+			if !bf.hasGlob() {
+				out = append(out, bf.String())
+				continue
+			}
+			matches := glob(bf.pattern(), r.dir)
+			if len(matches) == 0 {
+				out = append(out, bf.String())
+				continue
+			}
+			out = append(out, matches...)
+		}
+	}
+	return out, nil
+}
+
+// ifs returns $IFS, which is blank, tab and newline when unset.
+func (r *Runner) ifs() string {
+	if v, ok := r.getVar("IFS"); ok {
+		return v
+	}
+	return " \t\n"
+}
+
+// fchar is one byte of an expanded word. It remembers whether the byte was
+// quoted and whether it was written in the source rather than produced by an
+// expansion: glob characters only count when unquoted, braces only when they
+// are also literal.
+type fchar struct {
+	c       byte
+	quoted  bool
+	literal bool
+}
+
+type field []fchar
+
+func (f field) String() string {
+	b := make([]byte, len(f))
+	for i, ch := range f {
+		b[i] = ch.c
+	}
+	return string(b)
+}
+
+func (f field) pattern() string {
+	out := strings.Builder{}
+	for _, ch := range f {
+		if ch.quoted && strings.IndexByte(`*?[]\`, ch.c) >= 0 {
+			out.WriteByte('\\')
+			out.WriteByte(ch.c)
+		} else {
+			out.WriteByte(ch.c)
+		}
+	}
+	return out.String()
+}
+
+func (f field) hasGlob() bool {
+	for _, ch := range f {
+		if !ch.quoted && (ch.c == '*' || ch.c == '?' || ch.c == '[') {
+			return true
+		}
+	}
+	return false
+}
+
+func (f field) add(s string, quoted, literal bool) field {
+	for i := 0; i < len(s); i++ {
+		f = append(f, fchar{c: s[i], quoted: quoted, literal: literal})
+	}
+	return f
+}
+
+// expansion collects the fields a word expands to. With split set, the
+// unquoted results of expansions are split into fields at the bytes of ifs;
+// otherwise the word makes a single field.
+type expansion struct {
+	split  bool
+	ifs    string
+	fields []field
+	cur    field
+	// started is set when cur is a field even though it may be empty, as
+	// after "" or a separator other than white space.
+	started bool
+}
+
+// add appends s to the current field. Quoted text, even empty, makes a
+// field.
+func (e *expansion) add(s string, quoted, literal bool) {
+	e.cur = e.cur.add(s, quoted, literal)
+	if quoted {
+		e.started = true
+	}
+}
+
+// addSplit appends f, the unquoted result of an expansion, splitting it at
+// its unquoted bytes that are in IFS. White space in IFS separates fields
+// only once however much of it there is; any other IFS byte ends a field
+// even an empty one.
+func (e *expansion) addSplit(f field) {
+	for i := 0; i < len(f); {
+		ch := f[i]
+		if !e.split || !e.isSep(ch) {
+			e.cur = append(e.cur, ch)
+			e.started = e.started || ch.quoted
+			i++
+			continue
+		}
+		for i < len(f) && e.isSep(f[i]) && isIFSSpace(f[i].c) {
+			i++
+		}
+		if i < len(f) && e.isSep(f[i]) {
+			e.started = true
+			for i++; i < len(f) && e.isSep(f[i]) && isIFSSpace(f[i].c); i++ {
+			}
+		}
+		e.next()
+	}
+}
+
+// addList appends the elements of "$@" or ${name[@]}, each a field of its
+// own when the word is split.
+func (e *expansion) addList(elems []string, quoted bool) {
+	for i, s := range elems {
+		if i > 0 {
+			if e.split {
+				e.next()
+			} else {
+				e.add(" ", quoted, false)
+			}
+		}
+		if quoted {
+			e.add(s, true, false)
+		} else {
+			e.addSplit(field(nil).add(s, false, false))
+		}
+	}
+}
+
+func (e *expansion) isSep(ch fchar) bool {
+	return !ch.quoted && !ch.literal && strings.IndexByte(e.ifs, ch.c) >= 0
+}
+
+func isIFSSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+// next ends the current field.
+func (e *expansion) next() {
+	if e.started || len(e.cur) > 0 {
+		e.fields = append(e.fields, e.cur)
+	}
+	e.cur, e.started = nil, false
+}
+
+func (e *expansion) finish() []field {
+	e.next()
+	return e.fields
+}
+
+// expandField expands w into a single field, without field splitting.
+func (r *Runner) expandField(w *Word, assign bool) (field, error) {
+	e := &expansion{}
+	err := r.expandInto(e, w, assign)
+	return e.cur, err
+}
+
+// expandInto expands the parts of w into e. Literal text is handled here,
+// where tildes are expanded; see expandPartInto for the rest.
+func (r *Runner) expandInto(e *expansion, w *Word, assign bool) error {
+	for i, part := range w.Parts {
+		lit, ok := part.(*Lit)
+		if !ok {
+			if err := r.expandPartInto(e, part, false); err != nil {
+				return err
+			}
+			continue
+		}
+		v := lit.Value
+		last := i == len(w.Parts)-1
+		for j := 0; j < len(v); j++ {
+			c := v[j]
+			if c == '~' && (i == 0 && j == 0 || assign && j > 0 && v[j-1] == ':') {
+				if home, n, ok := r.tildePrefix(v[j:], assign, last); ok {
+					e.add(home, true, false)
+					j += n - 1
+					continue
+				}
+			}
+			if c == '\\' && j+1 < len(v) {
+				j++
+				e.cur = append(e.cur, fchar{c: v[j], quoted: true, literal: true})
+				continue
+			}
+			e.cur = append(e.cur, fchar{c: c, literal: true})
+		}
+	}
+	return nil
+}
+
+// expandPartInto expands part into e. quoted is set inside double quotes.
+// Literal text only gets here from inside double quotes or from the word of
+// a ${name op word}, where unquoted text is split like an expansion.
+func (r *Runner) expandPartInto(e *expansion, part WordPart, quoted bool) error {
+	switch part := part.(type) {
+	case *Lit:
+		if quoted {
+			e.add(unescape(part.Value, "$`\"\\"), true, true)
+			return nil
+		}
+		var f field
+		for j := 0; j < len(part.Value); j++ {
+			c := part.Value[j]
+			if c == '\\' && j+1 < len(part.Value) {
+				j++
+				f = append(f, fchar{c: part.Value[j], quoted: true, literal: true})
+				continue
+			}
+			f = append(f, fchar{c: c})
+		}
+		e.addSplit(f)
+	case *SglQuoted:
+		e.add(part.Value, true, true)
+	case *DblQuoted:
+		if len(part.Parts) == 0 {
+			e.add("", true, true)
+		}
+		for _, inner := range part.Parts {
+			if err := r.expandPartInto(e, inner, true); err != nil {
+				return err
+			}
+		}
+	case *ParamExp:
+		return r.expandParamInto(e, part, quoted)
+	default:
+		v, err := r.expandPart(part)
+		if err != nil {
+			return err
+		}
+		if quoted {
+			e.add(v, true, false)
+		} else {
+			e.addSplit(field(nil).add(v, false, false))
+		}
+	}
+	return nil
+}
+
+// tildePrefix expands the ~ or ~user prefix at the start of s. The prefix
+// runs up to the first slash (or colon in an assignment); when it runs to
+// the end of a literal that is followed by other parts, it is not expanded.
+func (r *Runner) tildePrefix(s string, assign, last bool) (string, int, bool) {
+	end := len(s)
+	for i := 1; i < len(s); i++ {
+		if s[i] == '/' || assign && s[i] == ':' {
+			end = i
+			break
+		}
+	}
+	if end == len(s) && !last {
+		return "", 0, false
+	}
+	name := s[1:end]
+	switch name {
+	case "":
+		home, ok := r.getVar("HOME")
+		if !ok {
+			u, err := user.Current()
+			if err != nil {
+				return "", 0, false
+			}
+			home = u.HomeDir
+		}
+		return home, end, true
+	case "+":
+		v, ok := r.getVar("PWD")
+		return v, end, ok
+	case "-":
+		v, ok := r.getVar("OLDPWD")
+		return v, end, ok
+	}
+	if !isName(strings.NewReplacer("-", "_", ".", "_").Replace(name)) {
+		return "", 0, false
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", 0, false
+	}
+	return u.HomeDir, end, true
+}
+
+func (r *Runner) expandPart(part WordPart) (string, error) {
+	switch part := part.(type) {
+	case *ParamExp:
+		return r.expandParam(part)
+	case *CmdSubst:
+		return r.commandSubst(part.List)
+	case *ProcSubst:
+		return r.processSubst(part)
+	case *ArithExp:
+		n, err := r.evalArithParts(part.Parts)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(n, 10), nil
+	}
+	return "", nil
+}
+
+// evalArithParts expands parts, the inside of $((...)) or a subscript, and
+// evaluates the result.
+func (r *Runner) evalArithParts(parts []WordPart) (int64, error) {
+	expr := strings.Builder{}
+	for _, inner := range parts {
+		if lit, ok := inner.(*Lit); ok {
+			expr.WriteString(unescape(lit.Value, "$`\"\\"))
+			continue
+		}
+		v, err := r.expandPart(inner)
+		if err != nil {
+			return 0, err
+		}
+		expr.WriteString(v)
+	}
+	return r.evalArith(expr.String())
+}
+
+// expandParam expands pe as it would be inside double quotes.
+func (r *Runner) expandParam(pe *ParamExp) (string, error) {
+	e := &expansion{}
+	err := r.expandParamInto(e, pe, true)
+	return e.cur.String(), err
+}
+
+// paramValue is the value of a parameter: a string, or the elements of $@,
+// $* or ${name[@]} when list is set.
+type paramValue struct {
+	str   string
+	elems []string
+	list  bool
+	set   bool
+}
+
+func (v paramValue) String() string {
+	if v.list {
+		return strings.Join(v.elems, " ")
+	}
+	return v.str
+}
+
+// lookupParam returns the value of the parameter pe names, with its
+// subscript applied.
+func (r *Runner) lookupParam(pe *ParamExp) (paramValue, error) {
+	if pe.Index != nil {
+		if wordIsLit(pe.Index, "@") || wordIsLit(pe.Index, "*") {
+			v, ok := r.vars[pe.Name]
+			if !ok {
+				return paramValue{list: true}, nil
+			}
+			elems := v.elems()
+			return paramValue{elems: elems, list: true, set: len(elems) > 0}, nil
+		}
+		n, err := r.evalArithParts(pe.Index.Parts)
+		if err != nil {
+			return paramValue{}, err
+		}
+		s, ok := r.getElem(pe.Name, int(n))
+		return paramValue{str: s, set: ok}, nil
+	}
+	if pe.Name == "@" || pe.Name == "*" {
+		return paramValue{elems: r.positional, list: true, set: len(r.positional) > 0}, nil
+	}
+	s, ok := r.specialParam(pe.Name)
+	if ok {
+		ok = r.paramSet(pe.Name)
+	} else {
+		s, ok = r.getVar(pe.Name)
+	}
+	return paramValue{str: s, set: ok}, nil
+}
+
+// expandParamInto expands pe into e, applying its operator.
+func (r *Runner) expandParamInto(e *expansion, pe *ParamExp, quoted bool) error {
+	v, err := r.lookupParam(pe)
+	if err != nil {
+		return err
+	}
+	if !v.set && !v.list && r.optNounset && !strings.ContainsAny(pe.Op, "-=?+") {
+		return r.unbound(paramText(pe))
+	}
+	if pe.Length {
+		n := utf8.RuneCountInString(v.str)
+		if v.list {
+			n = len(v.elems)
+		}
+		v = paramValue{str: strconv.Itoa(n), set: true}
+	}
+	switch op := pe.Op; op {
+	case "-", ":-", "=", ":=", "?", ":?", "+", ":+":
+		null := !v.set || op[0] == ':' && (v.String() == "")
+		switch op[len(op)-1] {
+		case '-':
+			if null {
+				return r.expandOperand(e, pe.Arg, quoted)
+			}
+		case '=':
+			if null {
+				if !isName(pe.Name) || pe.Index != nil {
+					return fmt.Errorf("%s: cannot assign in this way", paramText(pe))
+				}
+				s, err := r.expandOperandString(pe.Arg)
+				if err != nil {
+					return err
+				}
+				r.setVar(pe.Name, s)
+				v = paramValue{str: s, set: true}
+			}
+		case '?':
+			if null {
+				msg, err := r.expandOperandString(pe.Arg)
+				if err != nil {
+					return err
+				}
+				if msg == "" {
+					msg = "parameter null or not set"
+				}
+				if !r.interactive {
+					r.exiting, r.exitCode = true, 1
+				}
+				return fmt.Errorf("%s: %s", paramText(pe), msg)
+			}
+		case '+':
+			if null {
+				v = paramValue{set: true}
+			} else {
+				return r.expandOperand(e, pe.Arg, quoted)
+			}
+		}
+	case "#", "##", "%", "%%", "/", "//", "/#", "/%":
+		pattern, err := r.expandOperandPattern(pe.Arg)
+		if err != nil {
+			return err
+		}
+		repl := ""
+		if pe.Repl != nil {
+			if repl, err = r.expandOperandString(pe.Repl); err != nil {
+				return err
+			}
+		}
+		apply := func(s string) string {
+			if op[0] == '/' {
+				return replacePattern(s, pattern, repl, op[1:])
+			}
+			return trimPattern(s, pattern, op)
+		}
+		if v.list {
+			elems := make([]string, len(v.elems))
+			for i, s := range v.elems {
+				elems[i] = apply(s)
+			}
+			v.elems = elems
+		} else {
+			v.str = apply(v.str)
+		}
+	}
+
+	star := pe.Name == "*" || pe.Index != nil && wordIsLit(pe.Index, "*")
+	if v.list && !(star && quoted) {
+		e.addList(v.elems, quoted)
+		return nil
+	}
+	s := v.str
+	if v.list {
+		// "$*" joins the parameters with the first byte of IFS.
+		sep := r.ifs()
+		if len(sep) > 1 {
+			sep = sep[:1]
+		}
+		s = strings.Join(v.elems, sep)
+	}
+	if quoted {
+		e.add(s, true, false)
+	} else {
+		e.addSplit(field(nil).add(s, false, false))
+	}
+	return nil
+}
+
+// expandOperand expands w, the word of ${name:-word} or ${name:+word}, in
+// place of the parameter.
+func (r *Runner) expandOperand(e *expansion, w *Word, quoted bool) error {
+	if quoted && len(w.Parts) == 0 {
+		e.add("", true, false)
+	}
+	for _, part := range w.Parts {
+		if err := r.expandPartInto(e, part, quoted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expandOperandString expands w, the word of ${name:=word} or
+// ${name:?word} or the replacement of ${name/pattern/string}, to a string.
+func (r *Runner) expandOperandString(w *Word) (string, error) {
+	e := &expansion{}
+	err := r.expandOperand(e, w, false)
+	return e.cur.String(), err
+}
+
+// expandOperandPattern expands w, the pattern of ${name#pattern} and the
+// like, in which quoted characters match only themselves.
+func (r *Runner) expandOperandPattern(w *Word) (string, error) {
+	e := &expansion{}
+	err := r.expandOperand(e, w, false)
+	return e.cur.pattern(), err
+}
+
+// paramText is how pe names its parameter in error messages.
+func paramText(pe *ParamExp) string {
+	if pe.Index == nil {
+		return pe.Name
+	}
+	return pe.Name + "[" + (&printer{}).word(pe.Index) + "]"
+}
+
+// trimPattern removes the shortest (# and %) or longest (## and %%) prefix
+// (# and ##) or suffix (% and %%) of s that matches pattern.
+func trimPattern(s, pattern, op string) string {
+	cuts := runeStarts(s)
+	if op == "##" || op == "%" {
+		slices.Reverse(cuts)
+	}
+	for _, i := range cuts {
+		if op[0] == '#' && matchPattern(pattern, s[:i]) {
+			return s[i:]
+		}
+		if op[0] == '%' && matchPattern(pattern, s[i:]) {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// replacePattern replaces the longest match of pattern in s with repl: the
+// first match, every match when anchor is "/", or one at the start or end
+// of s when anchor is "#" or "%".
+func replacePattern(s, pattern, repl, anchor string) string {
+	cuts := runeStarts(s)
+	var b strings.Builder
+	last := 0
+	for k, i := range cuts {
+		if anchor == "#" && i > 0 {
+			break
+		}
+		if i < last {
+			continue
+		}
+		end := -1
+		switch anchor {
+		case "%":
+			if matchPattern(pattern, s[i:]) {
+				end = len(s)
+			}
+		case "#":
+			for j := len(cuts) - 1; j >= 0 && end < 0; j-- {
+				if matchPattern(pattern, s[:cuts[j]]) {
+					end = cuts[j]
+				}
+			}
+		default:
+			for j := len(cuts) - 1; j > k && end < 0; j-- {
+				if matchPattern(pattern, s[i:cuts[j]]) {
+					end = cuts[j]
+				}
+			}
+		}
+		if end < 0 {
+			continue
+		}
+		b.WriteString(s[last:i] + repl)
+		last = end
+		if anchor != "/" {
+			break
+		}
+	}
+	return b.String() + s[last:]
+}
+
+// runeStarts returns the offsets in s at which a character starts, and
+// len(s).
+func runeStarts(s string) []int {
+	cuts := make([]int, 0, len(s)+1)
+	for i := range s {
+		cuts = append(cuts, i)
+	}
+	return append(cuts, len(s))
+}
+
+// unbound reports the use of an unset parameter under set -u, which ends a
+// non-interactive shell with status 127.
+func (r *Runner) unbound(name string) error {
+	if !r.interactive {
+		r.exiting, r.exitCode = true, 127
+	}
+	if !isNameStart(name[0]) {
+		name = "$" + name
+	}
+	return fmt.Errorf("%s: unbound variable", name)
+}
+
+// commandSubst runs l as a subshell and returns its standard output with
+// trailing newlines removed.
+func (r *Runner) commandSubst(l *List) (string, error) {
+	pr, w, err := os.Pipe()
+	if err != nil {
+		return "", err
+	}
+	done := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(pr)
+		pr.Close()
+		done <- b
+	}()
+
+	savedOut, savedInteractive := r.stdout, r.interactive
+	r.stdout, r.interactive = w, false
+	r.substDepth++
+	r.substStatus = r.inSubshell(func() int {
+		return r.runList(l)
+	})
+	r.substDepth--
+	r.stdout, r.interactive = savedOut, savedInteractive
+	w.Close()
+
+	out := <-done
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// shellFd is a file the shell has put in its descriptor table at N.
+type shellFd struct {
+	n int
+	f *os.File
+}
+
+// processSubst starts the list of a <(list) or >(list) in a subshell
+// connected to a pipe, puts the other end in the descriptor table and
+// returns its /dev/fd path. The end is closed when the job using it is
+// done; see closeProcSubsts.
+func (r *Runner) processSubst(ps *ProcSubst) (string, error) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return "", err
+	}
+	fds := r.shellFds()
+	keep, give := pr, pw
+	if ps.Out {
+		keep, give = pw, pr
+		fds[0] = pr
+	} else {
+		fds[1] = pw
+	}
+	sub := r.subshell(fds)
+	goSubshell(sub, func(sub *Runner) int {
+		return sub.runList(ps.List)
+	}, []*os.File{give})
+	n := r.addShellFd(keep)
+	r.procSubsts = append(r.procSubsts, shellFd{n, keep})
+	return "/dev/fd/" + strconv.Itoa(n), nil
+}
+
+// closeProcSubsts closes the process substitutions opened since there
+// were mark of them.
+func (r *Runner) closeProcSubsts(mark int) {
+	for _, ps := range r.procSubsts[mark:] {
+		r.removeShellFd(ps.n, ps.f)
+		ps.f.Close()
+	}
+	r.procSubsts = r.procSubsts[:mark]
+}
+
+// unescape drops the backslash in front of the characters in special; an
+// empty special set means every character (unquoted context).
+func unescape(s, special string) string {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s
+	}
+	out := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && (special == "" || strings.IndexByte(special, s[i+1]) >= 0) {
+			i++
+		}
+		out.WriteByte(s[i])
+	}
+	return out.String()
+}
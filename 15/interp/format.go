@@ -0,0 +1,421 @@
+package interp
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Format parses src and prints it back in canonical form: one command per
+// line, bodies indented with a tab, and single spaces between words and
+// operators. Comments and single blank lines between commands are kept; a
+// compound command written on one line stays on one line.
+func Format(src string) (string, error) {
+	p := &parser{lx: newLexer(src)}
+	p.lx.keepComments = true
+	list, err := p.parse()
+	if err != nil {
+		return "", err
+	}
+	pr := &printer{comments: p.lx.comments}
+	pr.stmts(list, len(src))
+	return pr.buf.String(), nil
+}
+
+type printer struct {
+	buf    strings.Builder
+	indent int
+	// inline is set while printing a command inside another command's
+	// line, where compound commands take their one-line form.
+	inline int
+	// comments are those not printed yet, in source order.
+	comments []comment
+	// line is the last source line printed, to keep blank lines between
+	// commands; blockStart suppresses one at the top of a body.
+	line       int
+	blockStart bool
+	// docs are the here-documents whose bodies follow the current line.
+	docs []*Redirect
+}
+
+func (pr *printer) write(s string) {
+	pr.buf.WriteString(s)
+}
+
+func (pr *printer) startLine() {
+	pr.write(strings.Repeat("\t", pr.indent))
+}
+
+// newline ends the current line and writes out the here-documents that
+// were opened on it.
+func (pr *printer) newline() {
+	pr.write("\n")
+	for _, r := range pr.docs {
+		delim, _ := hereDocDelim(r.Target)
+		pr.write(r.Raw + delim + "\n")
+		pr.line += strings.Count(r.Raw, "\n") + 1
+	}
+	pr.docs = nil
+}
+
+// separate writes a blank line if there was one in the source before line.
+func (pr *printer) separate(line int) {
+	if !pr.blockStart && pr.buf.Len() > 0 && line > pr.line+1 {
+		pr.write("\n")
+	}
+	pr.blockStart = false
+}
+
+// commentsBefore prints the comments that start before offset on lines of
+// their own.
+func (pr *printer) commentsBefore(offset int) {
+	for len(pr.comments) > 0 && pr.comments[0].pos.Offset < offset {
+		c := pr.comments[0]
+		pr.comments = pr.comments[1:]
+		pr.separate(c.pos.Line)
+		pr.startLine()
+		pr.write(c.text)
+		pr.line = c.pos.Line
+		pr.newline()
+	}
+}
+
+// stmts prints l one command per line. end is the offset where the list's
+// enclosing command ends, and comments before it are printed as part of
+// the list; -1 leaves them to whatever follows.
+func (pr *printer) stmts(l *List, end int) {
+	for i, ao := range l.Items {
+		pr.commentsBefore(ao.Pos.Offset)
+		pr.separate(ao.Pos.Line)
+		pr.startLine()
+		pr.andOr(ao)
+		if ao.Background {
+			pr.write(" &")
+		}
+		stop := commandEnd(ao.Pos, ao.Text)
+		endLine := ao.Pos.Line + strings.Count(ao.Text, "\n")
+		next := end
+		if i+1 < len(l.Items) {
+			next = l.Items[i+1].Pos.Offset
+		}
+		if len(pr.comments) > 0 {
+			c := pr.comments[0]
+			if c.pos.Line == endLine && c.pos.Offset >= stop && (next < 0 || c.pos.Offset < next) {
+				pr.write(" " + c.text)
+				pr.comments = pr.comments[1:]
+			}
+		}
+		pr.line = endLine
+		pr.newline()
+	}
+	if end >= 0 {
+		pr.commentsBefore(end)
+	}
+}
+
+// inlineList prints l on the current line, as in the condition of an if.
+func (pr *printer) inlineList(l *List) {
+	pr.inline++
+	for i, ao := range l.Items {
+		if i > 0 {
+			if l.Items[i-1].Background {
+				pr.write(" ")
+			} else {
+				pr.write("; ")
+			}
+		}
+		pr.andOr(ao)
+		if ao.Background {
+			pr.write(" &")
+		}
+	}
+	pr.inline--
+}
+
+// body prints l as the indented body of a compound command, between the
+// line holding opener and the one holding closer.
+func (pr *printer) body(opener string, l *List, end int, closer string) {
+	pr.write(opener)
+	pr.newline()
+	pr.indent++
+	pr.blockStart = true
+	pr.stmts(l, end)
+	pr.indent--
+	pr.startLine()
+	pr.write(closer)
+}
+
+func (pr *printer) andOr(ao *AndOr) {
+	for i, pl := range ao.Pipelines {
+		if i > 0 {
+			pr.write(" " + ao.Ops[i-1] + " ")
+		}
+		if pl.TimePosix {
+			pr.write("time -p ")
+		} else if pl.Time {
+			pr.write("time ")
+		}
+		if pl.Bang {
+			pr.write("! ")
+		}
+		for j, c := range pl.Cmds {
+			if j > 0 {
+				pr.write(" | ")
+			}
+			pr.command(c)
+		}
+	}
+}
+
+func (pr *printer) command(c Command) {
+	switch c := c.(type) {
+	case *SimpleCommand:
+		var words []string
+		for _, a := range c.Assigns {
+			words = append(words, pr.assign(a))
+		}
+		for _, w := range c.Args {
+			words = append(words, pr.word(w))
+		}
+		for _, r := range c.Redirs {
+			words = append(words, pr.redirect(r))
+		}
+		pr.write(strings.Join(words, " "))
+	case *Redirected:
+		pr.command(c.Cmd)
+		for _, r := range c.Redirs {
+			pr.write(" " + pr.redirect(r))
+		}
+	case *FuncDecl:
+		pr.write(c.Name + "() ")
+		pr.command(c.Body)
+	case *BraceGroup:
+		if pr.multiline(c.Text) {
+			pr.body("{", c.List, commandEnd(c.Pos, c.Text), "}")
+			return
+		}
+		pr.write("{ ")
+		pr.inlineList(c.List)
+		pr.write("; }")
+	case *Subshell:
+		if pr.multiline(c.Text) {
+			pr.body("(", c.List, commandEnd(c.Pos, c.Text), ")")
+			return
+		}
+		pr.write("(")
+		pr.inlineList(c.List)
+		pr.write(")")
+	case *IfClause:
+		pr.ifClause(c)
+	case *WhileClause:
+		if c.Until {
+			pr.write("until ")
+		} else {
+			pr.write("while ")
+		}
+		pr.inlineList(c.Cond)
+		pr.loopBody(c.Body, c.Pos, c.Text)
+	case *ForClause:
+		pr.write("for " + c.Name)
+		if c.InSet {
+			pr.write(" in")
+			for _, w := range c.Items {
+				pr.write(" " + pr.word(w))
+			}
+		}
+		pr.loopBody(c.Body, c.Pos, c.Text)
+	case *CaseClause:
+		pr.caseClause(c)
+	case *Coproc:
+		pr.write("coproc ")
+		if c.Name != "COPROC" {
+			pr.write(c.Name + " ")
+		}
+		pr.command(c.Cmd)
+	}
+}
+
+// multiline reports whether a compound command spelled text takes more
+// than one line.
+func (pr *printer) multiline(text string) bool {
+	return pr.inline == 0 && strings.Contains(text, "\n")
+}
+
+// commandEnd returns the offset just past a command that starts at pos.
+func commandEnd(pos Pos, text string) int {
+	return pos.Offset + len(text)
+}
+
+func (pr *printer) ifClause(c *IfClause) {
+	multi := pr.multiline(c.Text)
+	part := func(keyword string, l *List, end int) {
+		if multi {
+			pr.body(keyword, l, end, "")
+			return
+		}
+		pr.write(keyword + " ")
+		pr.inlineList(l)
+		pr.write("; ")
+	}
+	pr.write("if ")
+	pr.inlineList(c.Cond)
+	last := commandEnd(c.Pos, c.Text)
+	thenEnd := last
+	if len(c.Elifs) > 0 || c.Else != nil {
+		thenEnd = -1
+	}
+	part("; then", c.Then, thenEnd)
+	for i, e := range c.Elifs {
+		pr.write("elif ")
+		pr.inlineList(e.Cond)
+		elifEnd := last
+		if i+1 < len(c.Elifs) || c.Else != nil {
+			elifEnd = -1
+		}
+		part("; then", e.Then, elifEnd)
+	}
+	if c.Else != nil {
+		part("else", c.Else, last)
+	}
+	pr.write("fi")
+}
+
+func (pr *printer) loopBody(l *List, pos Pos, text string) {
+	if pr.multiline(text) {
+		pr.body("; do", l, commandEnd(pos, text), "done")
+		return
+	}
+	pr.write("; do ")
+	pr.inlineList(l)
+	pr.write("; done")
+}
+
+func (pr *printer) caseClause(c *CaseClause) {
+	multi := pr.multiline(c.Text)
+	pr.write("case " + pr.word(c.Word) + " in")
+	for _, item := range c.Items {
+		patterns := make([]string, len(item.Patterns))
+		for i, w := range item.Patterns {
+			patterns[i] = pr.word(w)
+		}
+		if !multi {
+			pr.write(" " + strings.Join(patterns, " | ") + ") ")
+			if len(item.Body.Items) > 0 {
+				pr.inlineList(item.Body)
+				pr.write(" ")
+			}
+			pr.write(";;")
+			continue
+		}
+		pr.newline()
+		pr.startLine()
+		pr.write(strings.Join(patterns, " | ") + ")")
+		pr.indent++
+		pr.newline()
+		pr.blockStart = true
+		pr.stmts(item.Body, -1)
+		pr.startLine()
+		pr.write(";;")
+		pr.indent--
+	}
+	if multi {
+		pr.newline()
+		pr.startLine()
+	} else {
+		pr.write(" ")
+	}
+	pr.write("esac")
+}
+
+func (pr *printer) assign(a *Assign) string {
+	if a.Array != nil {
+		elems := make([]string, len(a.Array.Elems))
+		for i, w := range a.Array.Elems {
+			elems[i] = pr.word(w)
+		}
+		return a.Name + "=(" + strings.Join(elems, " ") + ")"
+	}
+	if a.Index != nil {
+		return a.Name + "[" + pr.word(a.Index) + "]=" + pr.word(a.Value)
+	}
+	return a.Name + "=" + pr.word(a.Value)
+}
+
+func (pr *printer) redirect(r *Redirect) string {
+	s := r.Op + pr.word(r.Target)
+	if strings.HasPrefix(s, r.Op+">(") || strings.HasPrefix(s, r.Op+"<(") {
+		// > >(cmd) would read back as the operator >> otherwise.
+		s = r.Op + " " + pr.word(r.Target)
+	}
+	if r.Fd >= 0 {
+		s = strconv.Itoa(r.Fd) + s
+	}
+	if r.Op == "<<" || r.Op == "<<-" {
+		pr.docs = append(pr.docs, r)
+	}
+	return s
+}
+
+func (pr *printer) word(w *Word) string {
+	var b strings.Builder
+	for _, part := range w.Parts {
+		pr.wordPart(&b, part)
+	}
+	return b.String()
+}
+
+func (pr *printer) wordPart(b *strings.Builder, part WordPart) {
+	switch part := part.(type) {
+	case *Lit:
+		b.WriteString(part.Value)
+	case *SglQuoted:
+		b.WriteString("'" + part.Value + "'")
+	case *DblQuoted:
+		b.WriteByte('"')
+		for _, inner := range part.Parts {
+			pr.wordPart(b, inner)
+		}
+		b.WriteByte('"')
+	case *ParamExp:
+		if !part.Braced {
+			b.WriteString("$" + part.Name)
+			return
+		}
+		b.WriteString("${")
+		if part.Length {
+			b.WriteByte('#')
+		}
+		b.WriteString(part.Name)
+		if part.Index != nil {
+			b.WriteString("[" + pr.word(part.Index) + "]")
+		}
+		if part.Op != "" {
+			b.WriteString(part.Op + pr.word(part.Arg))
+		}
+		if part.Repl != nil {
+			b.WriteString("/" + pr.word(part.Repl))
+		}
+		b.WriteByte('}')
+	case *CmdSubst:
+		sub := &printer{inline: 1}
+		sub.inlineList(part.List)
+		s := sub.buf.String()
+		if strings.HasPrefix(s, "(") {
+			s = " " + s
+		}
+		b.WriteString("$(" + s + ")")
+	case *ProcSubst:
+		sub := &printer{inline: 1}
+		sub.inlineList(part.List)
+		if part.Out {
+			b.WriteString(">(" + sub.buf.String() + ")")
+		} else {
+			b.WriteString("<(" + sub.buf.String() + ")")
+		}
+	case *ArithExp:
+		b.WriteString("$((")
+		for _, inner := range part.Parts {
+			pr.wordPart(b, inner)
+		}
+		b.WriteString("))")
+	}
+}
@@ -0,0 +1,45 @@
+package interp
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"echo   hi ;echo  there", "echo hi\necho there\n"},
+		{"a&&b||  c &", "a && b || c &\n"},
+		{"x=1   y=$(pwd)  cmd  2>&1 >out", "x=1 y=$(pwd) cmd 2>&1 >out\n"},
+		{"echo `date`|tr a b", "echo $(date) | tr a b\n"},
+		{"if a ;then b;fi", "if a; then b; fi\n"},
+		{"if a\nthen\n  b\nelse c\nfi", "if a; then\n\tb\nelse\n\tc\nfi\n"},
+		{"f ()  {\n  echo \"$1\"  # say it\n}", "f() {\n\techo \"$1\" # say it\n}\n"},
+		{"while x\ndo\n\n  y\ndone", "while x; do\n\ty\ndone\n"},
+		{"a\n\n\n# note\nb", "a\n\n# note\nb\n"},
+		{"case $x in\n a|b) c;;\n *) ;;\nesac", "case $x in\na | b)\n\tc\n\t;;\n*)\n\t;;\nesac\n"},
+		{"cat <<EOF |wc\n  $x\nEOF\necho", "cat <<EOF | wc\n  $x\nEOF\necho\n"},
+		{"(cd /; ls)  >/dev/null", "(cd /; ls) >/dev/null\n"},
+		{"echo $( (a) ) $((1+2))", "echo $( (a)) $((1+2))\n"},
+		{"diff  <( sort a )  <(sort b)>  >(wc -l)", "diff <(sort a) <(sort b) > >(wc -l)\n"},
+		{"coproc  cat;coproc UP {  tr a b ; }", "coproc cat\ncoproc UP { tr a b; }\n"},
+		{"echo ${a[1]}  ${a[@]}", "echo ${a[1]} ${a[@]}\n"},
+		{"a=( x  'y z'\n  w)  b[i+1]=$c", "a=(x 'y z' w) b[i+1]=$c\n"},
+		{"echo ${x:-\"a  b\"} ${#x} ${x##*/} ${x//a/b} ${x/#a}", "echo ${x:-\"a  b\"} ${#x} ${x##*/} ${x//a/b} ${x/#a}\n"},
+		{"time  -p a|b;time ! c", "time -p a | b\ntime ! c\n"},
+	}
+
+	for _, tt := range tests {
+		out, err := Format(tt.input)
+		if err != nil {
+			t.Errorf("Format(%q) unexpected error: %v", tt.input, err)
+			continue
+		}
+		if out != tt.expected {
+			t.Errorf("Format(%q) = %q; want %q", tt.input, out, tt.expected)
+			continue
+		}
+		if again, _ := Format(out); again != out {
+			t.Errorf("Format(%q) = %q; want it unchanged", out, again)
+		}
+	}
+}
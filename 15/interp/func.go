@@ -0,0 +1,144 @@
+package interp
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func (r *Runner) defineFunction(f *FuncDecl) int {
+	r.functions[f.Name] = f
+	return 0
+}
+
+// maxNesting bounds the depth of function calls, and separately of source
+// commands, so that runaway recursion fails rather than exhausting memory.
+const maxNesting = 1000
+
+// callFunction runs f with args as its positional parameters.
+func (r *Runner) callFunction(f *FuncDecl, args []string) int {
+	if len(r.localScopes) >= maxNesting {
+		fmt.Fprintf(r.stderr, "%s: maximum function nesting level exceeded\n", args[0])
+		return 1
+	}
+	savedPositional, savedDepth := r.positional, r.loopDepth
+	r.positional, r.loopDepth = args[1:], 0
+	scope := map[string]*variable{}
+	r.localScopes = append(r.localScopes, scope)
+	defer func() {
+		for name, old := range scope {
+			if old == nil {
+				delete(r.vars, name)
+			} else {
+				r.vars[name] = old
+			}
+		}
+		r.localScopes = r.localScopes[:len(r.localScopes)-1]
+		r.positional, r.loopDepth = savedPositional, savedDepth
+		r.returning = false
+	}()
+	return r.runCompound(f.Body)
+}
+
+func (r *Runner) builtinReturn(cmd *Cmd) int {
+	if len(r.localScopes) == 0 && r.sourceDepth == 0 {
+		fmt.Fprintln(cmd.Stderr, "return: can only `return' from a function or sourced script")
+		return 1
+	}
+	status := r.lastStatus
+	if len(cmd.Args) > 1 {
+		n, err := strconv.Atoi(cmd.Args[1])
+		if err != nil {
+			fmt.Fprintf(cmd.Stderr, "return: %s: numeric argument required\n", cmd.Args[1])
+			n = 2
+		}
+		status = n & 0xff
+	}
+	r.returning = true
+	return status
+}
+
+func (r *Runner) builtinLocal(cmd *Cmd) int {
+	if len(r.localScopes) == 0 {
+		fmt.Fprintln(cmd.Stderr, "local: can only be used in a function")
+		return 1
+	}
+	scope := r.localScopes[len(r.localScopes)-1]
+	status := 0
+	for _, arg := range cmd.Args[1:] {
+		name, value, hasValue := strings.Cut(arg, "=")
+		if !isName(name) {
+			fmt.Fprintf(cmd.Stderr, "local: `%s': not a valid identifier\n", arg)
+			status = 1
+			continue
+		}
+		if _, saved := scope[name]; !saved {
+			scope[name] = nil
+			if v, ok := r.vars[name]; ok {
+				scope[name] = v.clone()
+			}
+		}
+		if hasValue {
+			r.setVar(name, value)
+		} else {
+			r.unsetVar(name)
+		}
+	}
+	return status
+}
+
+func (r *Runner) builtinAlias(cmd *Cmd) int {
+	args := cmd.Args[1:]
+	if len(args) == 0 {
+		names := make([]string, 0, len(r.aliases))
+		for name := range r.aliases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		args = names
+	}
+	status := 0
+	for _, arg := range args {
+		name, value, define := strings.Cut(arg, "=")
+		if define {
+			if !validAliasName(name) {
+				fmt.Fprintf(cmd.Stderr, "alias: `%s': invalid alias name\n", name)
+				status = 1
+				continue
+			}
+			r.aliases[name] = value
+			continue
+		}
+		value, ok := r.aliases[name]
+		if !ok {
+			fmt.Fprintf(cmd.Stderr, "alias: %s: not found\n", name)
+			status = 1
+			continue
+		}
+		fmt.Fprintf(cmd.Stdout, "alias %s=%s\n", name, shellQuote(value))
+	}
+	return status
+}
+
+func (r *Runner) builtinUnalias(cmd *Cmd) int {
+	args := cmd.Args[1:]
+	if len(args) > 0 && args[0] == "-a" {
+		r.aliases = map[string]string{}
+		return 0
+	}
+	status := 0
+	for _, name := range args {
+		if _, ok := r.aliases[name]; !ok {
+			fmt.Fprintf(cmd.Stderr, "unalias: %s: not found\n", name)
+			status = 1
+			continue
+		}
+		delete(r.aliases, name)
+	}
+	return status
+}
+
+func validAliasName(name string) bool {
+	return name != "" && strings.IndexAny(name, " \t\n/$`=\\'\"&|;()<>") < 0
+}
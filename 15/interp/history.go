@@ -0,0 +1,169 @@
+package interp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// histMax is the number of lines kept in the history and its file.
+const histMax = 1000
+
+// history holds the lines entered at the interactive prompt. Each line is
+// appended to the history file as soon as it is read; base counts the lines
+// dropped from the front, so that entry numbers stay stable.
+type history struct {
+	entries []string
+	base    int
+	path    string
+}
+
+func (r *Runner) loadHistory() {
+	home, _ := r.getVar("HOME")
+	if home == "" {
+		return
+	}
+	r.hist.path = filepath.Join(home, ".gosh_history")
+	data, err := os.ReadFile(r.hist.path)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			r.hist.entries = append(r.hist.entries, line)
+		}
+	}
+	if n := len(r.hist.entries); n > histMax {
+		r.hist.entries = r.hist.entries[n-histMax:]
+		_ = os.WriteFile(r.hist.path, []byte(strings.Join(r.hist.entries, "\n")+"\n"), 0600)
+	}
+}
+
+// add records line unless it is blank or repeats the previous entry.
+func (h *history) add(line string) {
+	line = strings.TrimRight(line, "\n")
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if n := len(h.entries); n > 0 && h.entries[n-1] == line {
+		return
+	}
+	h.entries = append(h.entries, line)
+	if n := len(h.entries); n > histMax {
+		h.entries = h.entries[n-histMax:]
+		h.base += n - histMax
+	}
+	if h.path == "" {
+		return
+	}
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	_, _ = f.WriteString(line + "\n")
+}
+
+func (h *history) clear() {
+	h.base += len(h.entries)
+	h.entries = nil
+	if h.path != "" {
+		_ = os.Truncate(h.path, 0)
+	}
+}
+
+// expand replaces the history references !!, !N and !-N in line with the
+// entries they name. Single-quoted text and a backslash-escaped '!' are left
+// alone.
+func (h *history) expand(line string) (string, error) {
+	if strings.IndexByte(line, '!') < 0 {
+		return line, nil
+	}
+	out := strings.Builder{}
+	sgl, dbl := false, false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && !sgl && i+1 < len(line):
+			out.WriteByte(c)
+			i++
+			c = line[i]
+		case c == '\'' && !dbl:
+			sgl = !sgl
+		case c == '"' && !sgl:
+			dbl = !dbl
+		case c == '!' && !sgl:
+			entry, n, err := h.event(line[i+1:])
+			if err != nil {
+				return "", err
+			}
+			if n > 0 {
+				out.WriteString(entry)
+				i += n
+				continue
+			}
+		}
+		out.WriteByte(c)
+	}
+	return out.String(), nil
+}
+
+// event resolves the history reference at the start of s, which follows a
+// '!'. It returns the entry and the length of the reference, or 0 when s
+// does not start one.
+func (h *history) event(s string) (string, int, error) {
+	var n int
+	switch {
+	case strings.HasPrefix(s, "!"):
+		n = 1
+	case s != "" && (s[0] == '-' || s[0] >= '0' && s[0] <= '9'):
+		n = strings.IndexFunc(s[1:], func(r rune) bool { return r < '0' || r > '9' }) + 1
+		if n == 0 {
+			n = len(s)
+		}
+	default:
+		return "", 0, nil
+	}
+	ref := s[:n]
+	i := len(h.entries) - 1
+	if ref != "!" {
+		num, err := strconv.Atoi(ref)
+		if err != nil {
+			return "", 0, nil
+		}
+		if num < 0 {
+			i = len(h.entries) + num
+		} else {
+			i = num - h.base - 1
+		}
+	}
+	if i < 0 || i >= len(h.entries) {
+		return "", 0, fmt.Errorf("!%s: event not found", ref)
+	}
+	return h.entries[i], n, nil
+}
+
+func (r *Runner) builtinHistory(cmd *Cmd) int {
+	args := cmd.Args[1:]
+	if len(args) > 0 && args[0] == "-c" {
+		r.hist.clear()
+		return 0
+	}
+	start := 0
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			fmt.Fprintf(cmd.Stderr, "history: %s: numeric argument required\n", args[0])
+			return 1
+		}
+		if n < len(r.hist.entries) {
+			start = len(r.hist.entries) - n
+		}
+	}
+	for i := start; i < len(r.hist.entries); i++ {
+		fmt.Fprintf(cmd.Stdout, "%5d  %s\n", r.hist.base+i+1, r.hist.entries[i])
+	}
+	return 0
+}
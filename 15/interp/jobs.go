@@ -0,0 +1,676 @@
+package interp
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+type jobState int
+
+const (
+	jobRunning jobState = iota
+	jobStopped
+	jobDone
+)
+
+func (s jobState) String() string {
+	switch s {
+	case jobRunning:
+		return "Running"
+	case jobStopped:
+		return "Stopped"
+	}
+	return "Done"
+}
+
+// proc is one member of a job: a child process, or a builtin or subshell
+// running in a goroutine that reports its exit status on result. sub is the
+// runner of a subshell, which has a made up pid. killedBy is the signal
+// that would have killed a builtin run as a process of its own.
+type proc struct {
+	pid      int
+	status   syscall.WaitStatus
+	done     bool
+	stopped  bool
+	result   chan int
+	sub      *Runner
+	killedBy syscall.Signal
+}
+
+// finish records the exit status of a builtin or subshell. One that a
+// signal ended counts as killed by it.
+func (p *proc) finish(status int) {
+	p.done = true
+	p.status = syscall.WaitStatus(status << 8)
+	sig := p.killedBy
+	if p.sub != nil {
+		sig = p.sub.killedBy
+	}
+	if sig != 0 {
+		p.status = syscall.WaitStatus(sig)
+	}
+}
+
+type jobEntry struct {
+	id     int
+	pgid   int
+	procs  []*proc
+	text   string
+	tmodes *syscall.Termios
+}
+
+const ttyFd = 0
+
+func (j *jobEntry) state() jobState {
+	running := false
+	for _, p := range j.procs {
+		if p.done {
+			continue
+		}
+		if !p.stopped {
+			running = true
+		}
+	}
+	if running {
+		return jobRunning
+	}
+	for _, p := range j.procs {
+		if !p.done {
+			return jobStopped
+		}
+	}
+	return jobDone
+}
+
+func (j *jobEntry) update(pid int, ws syscall.WaitStatus) {
+	for _, p := range j.procs {
+		if p.pid != pid {
+			continue
+		}
+		switch {
+		case ws.Exited(), ws.Signaled():
+			p.done = true
+			p.status = ws
+		case ws.Stopped():
+			p.stopped = true
+		case ws.Continued():
+			p.stopped = false
+		}
+		return
+	}
+}
+
+// exitStatus is the status of the last process, or with pipefail that of
+// the last one to fail.
+func (j *jobEntry) exitStatus(pipefail bool) int {
+	if len(j.procs) == 0 {
+		return 0
+	}
+	if pipefail {
+		for i := len(j.procs) - 1; i >= 0; i-- {
+			if status := j.procs[i].exitStatus(); status != 0 {
+				return status
+			}
+		}
+		return 0
+	}
+	return j.procs[len(j.procs)-1].exitStatus()
+}
+
+// exitStatus is 128 plus the signal number for a process killed by a signal.
+func (p *proc) exitStatus() int {
+	if p.status.Signaled() {
+		return 128 + int(p.status.Signal())
+	}
+	return p.status.ExitStatus()
+}
+
+// EnableJobControl puts the shell in its own process group in the
+// foreground of the terminal, if there is one, and reports whether it did.
+func (r *Runner) EnableJobControl() bool {
+	r.start()
+	if _, err := tcgetattr(ttyFd); err != nil {
+		return false
+	}
+	r.interactive = true
+	r.catchSignals()
+	signal.Notify(r.sigc, syscall.SIGTERM)
+	// The shell itself is not stopped from the terminal, only its jobs.
+	stopc := make(chan os.Signal, 1)
+	signal.Notify(stopc, syscall.SIGTSTP, syscall.SIGTTIN, syscall.SIGTTOU)
+	go func() {
+		for range stopc {
+		}
+	}()
+	_ = syscall.Setpgid(0, 0)
+	r.shellPgid = syscall.Getpgrp()
+	_ = tcsetpgrp(ttyFd, r.shellPgid)
+	r.shellTmodes, _ = tcgetattr(ttyFd)
+	return true
+}
+
+// addJob numbers j if it is new and makes it the current job.
+func (r *Runner) addJob(j *jobEntry) {
+	if j.id == 0 {
+		for _, other := range r.jobTable {
+			if other.id >= j.id {
+				j.id = other.id + 1
+			}
+		}
+		if j.id == 0 {
+			j.id = 1
+		}
+	}
+	r.removeJob(j)
+	r.jobTable = append(r.jobTable, j)
+}
+
+func (r *Runner) removeJob(j *jobEntry) {
+	for i, other := range r.jobTable {
+		if other == j {
+			r.jobTable = append(r.jobTable[:i], r.jobTable[i+1:]...)
+			return
+		}
+	}
+}
+
+func (r *Runner) waitForeground(j *jobEntry) int {
+	if r.interactive && j.pgid != 0 {
+		_ = tcsetpgrp(ttyFd, j.pgid)
+	}
+	r.waitJob(j)
+	if r.interactive {
+		j.tmodes, _ = tcgetattr(ttyFd)
+		_ = tcsetpgrp(ttyFd, r.shellPgid)
+		if r.shellTmodes != nil {
+			_ = tcsetattr(ttyFd, r.shellTmodes)
+		}
+	}
+	if r.interactive && j.state() == jobStopped {
+		r.addJob(j)
+		fmt.Fprintf(r.stdout, "\n[%d]%s  %-24s%s\n", j.id, r.jobMark(j), jobStopped, j.text)
+		return 128 + int(syscall.SIGTSTP)
+	}
+	r.removeJob(j)
+	r.reportSignaled(j)
+	return j.exitStatus(r.optPipefail)
+}
+
+// waitJob waits until each process of j has finished or, with job
+// control, stopped. Without job control a stopped process is waited for
+// until it is continued and finishes.
+func (r *Runner) waitJob(j *jobEntry) {
+	r.setWaiting(j.procs)
+	defer r.setWaiting(nil)
+	options := 0
+	if r.interactive {
+		options = syscall.WUNTRACED
+	}
+	for _, p := range j.procs {
+		if p.result != nil && !p.done {
+			p.finish(<-p.result)
+			continue
+		}
+		for !p.done && !p.stopped {
+			var ws syscall.WaitStatus
+			var ru syscall.Rusage
+			pid, err := syscall.Wait4(p.pid, &ws, options, &ru)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil {
+				p.done = true
+				break
+			}
+			r.maxRSS = max(r.maxRSS, ru.Maxrss)
+			j.update(pid, ws)
+		}
+	}
+}
+
+// reportSignaled tells the user about a foreground job killed by a signal.
+// The shell is spared a Ctrl-C typed at its foreground job, so a SIGINT
+// death is taken as an interrupt of the shell's own commands too, and ends
+// a subshell running alongside it.
+func (r *Runner) reportSignaled(j *jobEntry) {
+	for _, p := range j.procs {
+		if !p.status.Signaled() {
+			continue
+		}
+		sig := p.status.Signal()
+		if r.async && !r.jobGroups && (sig == syscall.SIGINT || sig == syscall.SIGQUIT) && !r.hasTrap(sig) {
+			// A subshell shares the process group of the job, so Ctrl-C
+			// was meant for it too, even if the shell has not passed it
+			// on yet.
+			r.exiting, r.exitCode, r.killedBy = true, 128+int(sig), sig
+			return
+		}
+		if sig == syscall.SIGINT && r.interactive {
+			r.interruptReq = true
+			fmt.Fprintln(r.stdout)
+			return
+		}
+		if sig == syscall.SIGPIPE || !r.interactive {
+			continue
+		}
+		msg := signalDescription(sig)
+		if p.status.CoreDump() {
+			msg += " (core dumped)"
+		}
+		fmt.Fprintln(r.stderr, msg)
+		return
+	}
+}
+
+// signalDescription is the message strsignal gives for sig, capitalized.
+func signalDescription(sig syscall.Signal) string {
+	msg := sig.String()
+	if msg == "" {
+		return msg
+	}
+	return strings.ToUpper(msg[:1]) + msg[1:]
+}
+
+// reapedMax bounds the number of exit statuses kept for wait after their
+// jobs are gone.
+const reapedMax = 1024
+
+type reapedProc struct {
+	pid    int
+	status int
+}
+
+// reapJobs collects the status of the jobs that changed and drops those
+// that are done, telling the user of an interactive shell about them.
+func (r *Runner) reapJobs() {
+	r.pollJobs()
+	var live []*jobEntry
+	for _, j := range r.jobTable {
+		if j.state() != jobDone {
+			live = append(live, j)
+			continue
+		}
+		for _, p := range j.procs {
+			if p.pid > 0 {
+				r.reaped = append(r.reaped, reapedProc{p.pid, p.exitStatus()})
+			}
+		}
+		if r.interactive {
+			fmt.Fprintf(r.stdout, "[%d]%s  %-24s%s\n", j.id, r.jobMark(j), jobDone, j.text)
+		}
+	}
+	r.jobTable = live
+	if n := len(r.reaped); n > reapedMax {
+		r.reaped = slices.Delete(r.reaped, 0, n-reapedMax)
+	}
+}
+
+// reapedStatus returns the exit status of the process pid of a job that
+// reapJobs dropped.
+func (r *Runner) reapedStatus(pid int) (int, bool) {
+	for i := len(r.reaped) - 1; i >= 0; i-- {
+		if r.reaped[i].pid == pid {
+			return r.reaped[i].status, true
+		}
+	}
+	return 0, false
+}
+
+// pollJobs collects the status of the processes of jobs that have finished,
+// stopped or continued, without waiting.
+func (r *Runner) pollJobs() {
+	for _, j := range r.jobTable {
+		for _, p := range j.procs {
+			if p.result != nil && !p.done {
+				select {
+				case status := <-p.result:
+					p.finish(status)
+				default:
+				}
+				continue
+			}
+			for !p.done {
+				var ws syscall.WaitStatus
+				pid, err := syscall.Wait4(p.pid, &ws, syscall.WNOHANG|syscall.WUNTRACED|syscall.WCONTINUED, nil)
+				if err == syscall.EINTR {
+					continue
+				}
+				if err != nil {
+					p.done = true
+					break
+				}
+				if pid <= 0 {
+					break
+				}
+				j.update(pid, ws)
+			}
+		}
+	}
+}
+
+func (r *Runner) jobMark(j *jobEntry) string {
+	n := len(r.jobTable)
+	switch {
+	case n > 0 && r.jobTable[n-1] == j:
+		return "+"
+	case n > 1 && r.jobTable[n-2] == j:
+		return "-"
+	}
+	return " "
+}
+
+func (r *Runner) findJob(spec string) (*jobEntry, error) {
+	if len(r.jobTable) == 0 {
+		return nil, fmt.Errorf("no current job")
+	}
+	if spec == "" || spec == "%" || spec == "%%" || spec == "%+" {
+		return r.jobTable[len(r.jobTable)-1], nil
+	}
+	if spec == "%-" {
+		if len(r.jobTable) < 2 {
+			return nil, fmt.Errorf("%s: no such job", spec)
+		}
+		return r.jobTable[len(r.jobTable)-2], nil
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(spec, "%"))
+	if err != nil {
+		return nil, fmt.Errorf("%s: no such job", spec)
+	}
+	for _, j := range r.jobTable {
+		if j.id == id {
+			return j, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: no such job", spec)
+}
+
+// findProc returns the job with a process of the given pid, made up for a
+// subshell or not, and the proc for it, or nils.
+func (r *Runner) findProc(pid int) (*jobEntry, *proc) {
+	for _, j := range r.jobTable {
+		for _, p := range j.procs {
+			if p.pid == pid {
+				return j, p
+			}
+		}
+	}
+	return nil, nil
+}
+
+func continueJob(j *jobEntry) error {
+	for _, p := range j.procs {
+		p.stopped = false
+	}
+	return signalJob(j, syscall.SIGCONT)
+}
+
+// signalJob sends sig to the subshells of j and to its process group, or
+// to each of its live processes when it has no group of its own.
+func signalJob(j *jobEntry, sig syscall.Signal) error {
+	for _, p := range j.procs {
+		if p.sub != nil && !p.done {
+			p.sub.signal(sig)
+		}
+	}
+	if j.pgid != 0 {
+		return syscall.Kill(-j.pgid, sig)
+	}
+	var err error
+	for _, p := range j.procs {
+		if !p.done && p.result == nil && p.pid > 0 {
+			if e := syscall.Kill(p.pid, sig); e != nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+// builtinKill sends a signal, SIGTERM unless given as -NAME, -N or -s NAME,
+// to each pid or %job. kill -l lists the signal names, or translates the
+// given numbers and exit statuses to names and names to numbers.
+func (r *Runner) builtinKill(cmd *Cmd) int {
+	args := cmd.Args[1:]
+	if len(args) > 0 && args[0] == "-l" {
+		return listSignals(cmd, args[1:])
+	}
+	sig := syscall.SIGTERM
+	if len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' && args[0] != "--" {
+		name := args[0][1:]
+		args = args[1:]
+		if name == "s" || name == "n" {
+			if len(args) == 0 {
+				fmt.Fprintf(cmd.Stderr, "kill: -%s: option requires an argument\n", name)
+				return 2
+			}
+			name, args = args[0], args[1:]
+		}
+		var ok bool
+		if sig, ok = parseSignal(name); !ok {
+			fmt.Fprintf(cmd.Stderr, "kill: %s: invalid signal specification\n", name)
+			return 1
+		}
+	}
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		fmt.Fprintln(cmd.Stderr, "kill: usage: kill [-s sigspec | -n signum | -sigspec] pid | jobspec ... or kill -l [sigspec]")
+		return 2
+	}
+	status := 0
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "%") {
+			j, err := r.findJob(arg)
+			if err == nil {
+				err = signalJob(j, sig)
+			}
+			if err == nil && j.state() == jobStopped && (sig == syscall.SIGTERM || sig == syscall.SIGHUP) {
+				err = continueJob(j)
+			}
+			if err != nil {
+				fmt.Fprintln(cmd.Stderr, "kill:", err)
+				status = 1
+			}
+			continue
+		}
+		pid, err := strconv.Atoi(arg)
+		if err != nil {
+			fmt.Fprintf(cmd.Stderr, "kill: %s: arguments must be process or job IDs\n", arg)
+			status = 1
+			continue
+		}
+		if pid == os.Getpid() && sig != 0 && (r.shellCatches(sig) || r.hasTrap(sig)) {
+			// Queue it directly, so the trap runs before the next command.
+			r.queueSignal(sig)
+			continue
+		}
+		if _, p := r.findProc(pid); p != nil && p.sub != nil {
+			p.sub.signal(sig)
+			continue
+		}
+		if err := syscall.Kill(pid, sig); err != nil {
+			fmt.Fprintf(cmd.Stderr, "kill: (%d) - %v\n", pid, err)
+			status = 1
+		}
+	}
+	return status
+}
+
+func listSignals(cmd *Cmd, args []string) int {
+	if len(args) == 0 {
+		names := make([]string, len(signalNames))
+		for i, e := range signalNames {
+			names[i] = e.name
+		}
+		fmt.Fprintln(cmd.Stdout, strings.Join(names, " "))
+		return 0
+	}
+	status := 0
+	for _, arg := range args {
+		if n, err := strconv.Atoi(arg); err == nil {
+			if n > 128 {
+				n -= 128
+			}
+			if name := signalName(syscall.Signal(n)); n > 0 && name != "" {
+				fmt.Fprintln(cmd.Stdout, name)
+				continue
+			}
+		} else if sig, ok := parseSignal(arg); ok && sig != 0 {
+			fmt.Fprintln(cmd.Stdout, int(sig))
+			continue
+		}
+		fmt.Fprintf(cmd.Stderr, "kill: %s: invalid signal specification\n", arg)
+		status = 1
+	}
+	return status
+}
+
+func (r *Runner) builtinJobs(cmd *Cmd) int {
+	r.reapJobs()
+	for _, j := range r.jobTable {
+		text := j.text
+		if j.state() == jobRunning {
+			text += " &"
+		}
+		fmt.Fprintf(cmd.Stdout, "[%d]%s  %-24s%s\n", j.id, r.jobMark(j), j.state(), text)
+	}
+	return 0
+}
+
+func (r *Runner) builtinFg(cmd *Cmd) int {
+	spec := ""
+	if len(cmd.Args) > 1 {
+		spec = cmd.Args[1]
+	}
+	j, err := r.findJob(spec)
+	if err != nil {
+		fmt.Fprintln(cmd.Stderr, "fg:", err)
+		return 1
+	}
+	fmt.Fprintln(cmd.Stdout, j.text)
+	if r.interactive && j.pgid != 0 {
+		_ = tcsetpgrp(ttyFd, j.pgid)
+		if j.tmodes != nil {
+			_ = tcsetattr(ttyFd, j.tmodes)
+		}
+	}
+	if err := continueJob(j); err != nil {
+		fmt.Fprintln(cmd.Stderr, "fg:", err)
+		return 1
+	}
+	return r.waitForeground(j)
+}
+
+func (r *Runner) builtinBg(cmd *Cmd) int {
+	spec := ""
+	if len(cmd.Args) > 1 {
+		spec = cmd.Args[1]
+	}
+	j, err := r.findJob(spec)
+	if err != nil {
+		fmt.Fprintln(cmd.Stderr, "bg:", err)
+		return 1
+	}
+	if j.state() != jobStopped {
+		fmt.Fprintf(cmd.Stderr, "bg: job %d already in background\n", j.id)
+		return 0
+	}
+	if err := continueJob(j); err != nil {
+		fmt.Fprintln(cmd.Stderr, "bg:", err)
+		return 1
+	}
+	fmt.Fprintf(cmd.Stdout, "[%d]%s %s &\n", j.id, r.jobMark(j), j.text)
+	return 0
+}
+
+// builtinWait waits for the jobs given by pid or %job, or for all of them,
+// and returns the exit status of the last one given: that of the process
+// with the pid, or that of the job.
+func (r *Runner) builtinWait(cmd *Cmd) int {
+	if len(cmd.Args) == 1 {
+		for _, j := range slices.Clone(r.jobTable) {
+			r.waitJob(j)
+		}
+		return 0
+	}
+	status := 0
+	for _, arg := range cmd.Args[1:] {
+		if strings.HasPrefix(arg, "%") {
+			j, err := r.findJob(arg)
+			if err != nil {
+				fmt.Fprintln(cmd.Stderr, "wait:", err)
+				status = 127
+				continue
+			}
+			r.waitJob(j)
+			status = j.exitStatus(r.optPipefail)
+			continue
+		}
+		pid, err := strconv.Atoi(arg)
+		if err != nil {
+			fmt.Fprintf(cmd.Stderr, "wait: `%s': not a pid or valid job spec\n", arg)
+			status = 1
+			continue
+		}
+		j, p := r.findProc(pid)
+		if p == nil {
+			if st, ok := r.reapedStatus(pid); ok {
+				status = st
+				continue
+			}
+			fmt.Fprintf(cmd.Stderr, "wait: pid %d is not a child of this shell\n", pid)
+			status = 127
+			continue
+		}
+		r.waitJob(j)
+		status = p.exitStatus()
+	}
+	return status
+}
+
+func tcgetattr(fd int) (*syscall.Termios, error) {
+	t := &syscall.Termios{}
+	_, _, e := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TCGETS, uintptr(unsafe.Pointer(t)))
+	if e != 0 {
+		return nil, e
+	}
+	return t, nil
+}
+
+func tcsetattr(fd int, t *syscall.Termios) error {
+	_, _, e := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TCSETS, uintptr(unsafe.Pointer(t)))
+	if e != 0 {
+		return e
+	}
+	return nil
+}
+
+// tcsetpgrp hands the terminal to pgid. SIGTTOU is blocked on the calling
+// thread for the duration: the shell is a background group when it takes the
+// terminal back, and ignoring the signal instead would leak SIG_IGN into
+// every child we exec.
+func tcsetpgrp(fd int, pgid int) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	const sigBlock, sigSetmask = 0, 2
+	set := uint64(1) << (uint(syscall.SIGTTOU) - 1)
+	var old uint64
+	syscall.RawSyscall6(syscall.SYS_RT_SIGPROCMASK, sigBlock, uintptr(unsafe.Pointer(&set)), uintptr(unsafe.Pointer(&old)), 8, 0, 0)
+	defer syscall.RawSyscall6(syscall.SYS_RT_SIGPROCMASK, sigSetmask, uintptr(unsafe.Pointer(&old)), 0, 8, 0, 0)
+
+	p := int32(pgid)
+	_, _, e := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TIOCSPGRP, uintptr(unsafe.Pointer(&p)))
+	if e != 0 {
+		return e
+	}
+	return nil
+}
@@ -0,0 +1,777 @@
+package interp
+
+import (
+	"strings"
+)
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokWord
+	tokOp
+	tokNewline
+)
+
+type token struct {
+	kind tokKind
+	pos  Pos
+	end  int
+	op   string
+	fd   int
+	word *Word
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of input"
+	case tokNewline:
+		return "newline"
+	case tokOp:
+		return "`" + t.op + "'"
+	}
+	if len(t.word.Parts) == 1 {
+		if lit, ok := t.word.Parts[0].(*Lit); ok {
+			return "`" + lit.Value + "'"
+		}
+	}
+	return "word"
+}
+
+// operators are matched longest first.
+var operators = []string{
+	"&>>", "<<-", "<<<",
+	"&&", "||", ";;", "<<", ">>", "<&", ">&", "<>", ">|", "&>",
+	"&", "|", ";", "(", ")", "<", ">",
+}
+
+type lexer struct {
+	src  string
+	off  int
+	line int
+	col  int
+
+	// heredocs are the here-documents whose bodies start after the next
+	// newline.
+	heredocs []*Redirect
+	// heredoc is set while lexing the body of an unquoted here-document,
+	// where a backslash does not quote a double quote.
+	heredoc bool
+	// dquote counts the double-quoted strings being lexed, inside which a
+	// single quote in ${name:-word} is an ordinary character.
+	dquote int
+	// aliases are the spans of source that replaced an alias, which is not
+	// expanded again inside its own replacement.
+	aliases []aliasSpan
+	// comments collects the comments skipped, when keepComments is set,
+	// for the formatter.
+	keepComments bool
+	comments     []comment
+}
+
+// comment is a # comment, from the # to the end of the line.
+type comment struct {
+	pos  Pos
+	text string
+}
+
+type aliasSpan struct {
+	name string
+	end  int
+}
+
+func newLexer(src string) *lexer {
+	return &lexer{src: src, line: 1, col: 1}
+}
+
+func (l *lexer) pos() Pos {
+	return Pos{Offset: l.off, Line: l.line, Col: l.col}
+}
+
+func (l *lexer) eof() bool {
+	return l.off >= len(l.src)
+}
+
+func (l *lexer) peek() byte {
+	if l.eof() {
+		return 0
+	}
+	return l.src[l.off]
+}
+
+func (l *lexer) peekAt(n int) byte {
+	if l.off+n >= len(l.src) {
+		return 0
+	}
+	return l.src[l.off+n]
+}
+
+func (l *lexer) advance() byte {
+	c := l.src[l.off]
+	l.off++
+	if c == '\n' {
+		l.line++
+		l.col = 1
+	} else {
+		l.col++
+	}
+	return c
+}
+
+func (l *lexer) fail(pos Pos, msg string) {
+	panic(&SyntaxError{Pos: pos, Msg: msg})
+}
+
+func (l *lexer) failIncomplete(pos Pos, msg string) {
+	panic(&SyntaxError{Pos: pos, Msg: msg, Incomplete: true})
+}
+
+func (l *lexer) skipBlanks() {
+	for !l.eof() {
+		c := l.peek()
+		switch {
+		case c == ' ' || c == '\t':
+			l.advance()
+		case c == '\\' && l.peekAt(1) == '\n':
+			l.advance()
+			l.advance()
+		case c == '#':
+			pos := l.pos()
+			for !l.eof() && l.peek() != '\n' {
+				l.advance()
+			}
+			if l.keepComments {
+				l.comments = append(l.comments, comment{pos: pos, text: l.src[pos.Offset:l.off]})
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (l *lexer) next() token {
+	l.skipBlanks()
+	pos := l.pos()
+	if l.eof() {
+		if len(l.heredocs) > 0 {
+			l.failIncomplete(l.heredocs[0].Pos, "unterminated here-document")
+		}
+		return token{kind: tokEOF, pos: pos, end: l.off}
+	}
+	c := l.peek()
+	if c == '\n' {
+		l.advance()
+		t := token{kind: tokNewline, pos: pos, end: l.off}
+		docs := l.heredocs
+		l.heredocs = nil
+		for _, r := range docs {
+			l.hereDoc(r)
+		}
+		return t
+	}
+	if fd, n := l.ioNumber(); n > 0 {
+		for i := 0; i < n; i++ {
+			l.advance()
+		}
+		t := l.operator()
+		t.pos = pos
+		t.fd = fd
+		return t
+	}
+	if isOpStart(c) && !isProcSubst(c, l.peekAt(1)) {
+		return l.operator()
+	}
+	w := l.word()
+	return token{kind: tokWord, pos: pos, end: l.off, word: w, fd: -1}
+}
+
+// ioNumber reports a run of digits directly followed by a redirection
+// operator, as in 2>file.
+func (l *lexer) ioNumber() (int, int) {
+	n, fd := 0, 0
+	for {
+		c := l.peekAt(n)
+		if c < '0' || c > '9' {
+			break
+		}
+		fd = fd*10 + int(c-'0')
+		n++
+	}
+	if n == 0 {
+		return 0, 0
+	}
+	if c := l.peekAt(n); c != '<' && c != '>' {
+		return 0, 0
+	}
+	return fd, n
+}
+
+func (l *lexer) operator() token {
+	pos := l.pos()
+	rest := l.src[l.off:]
+	for _, op := range operators {
+		if strings.HasPrefix(rest, op) {
+			for range op {
+				l.advance()
+			}
+			return token{kind: tokOp, pos: pos, end: l.off, op: op, fd: -1}
+		}
+	}
+	l.fail(pos, "unexpected character "+string(l.peek()))
+	return token{}
+}
+
+// isProcSubst reports whether c and next start <( or >(, which begin a
+// word rather than a redirection.
+func isProcSubst(c, next byte) bool {
+	return (c == '<' || c == '>') && next == '('
+}
+
+// procSubst lexes <(list) or >(list).
+func (l *lexer) procSubst() *ProcSubst {
+	out := l.advance() == '>'
+	l.advance()
+	start := l.off
+	p := &parser{lx: l}
+	p.next()
+	list := p.list()
+	if !p.isOp(")") {
+		p.unexpected()
+	}
+	return &ProcSubst{List: list, Text: strings.TrimSpace(l.src[start:p.tok.pos.Offset]), Out: out}
+}
+
+func isOpStart(c byte) bool {
+	return strings.IndexByte("&|;()<>", c) >= 0
+}
+
+func isWordBreak(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || isOpStart(c)
+}
+
+func (l *lexer) word() *Word {
+	w := &Word{Pos: l.pos()}
+	lit := strings.Builder{}
+	flush := func() {
+		if lit.Len() > 0 {
+			w.Parts = append(w.Parts, &Lit{Value: lit.String()})
+			lit.Reset()
+		}
+	}
+	for !l.eof() {
+		c := l.peek()
+		if len(w.Parts) == 0 && lit.Len() == 0 && isProcSubst(c, l.peekAt(1)) {
+			w.Parts = append(w.Parts, l.procSubst())
+			continue
+		}
+		if isWordBreak(c) {
+			break
+		}
+		switch c {
+		case '\\':
+			pos := l.pos()
+			l.advance()
+			if l.eof() {
+				l.failIncomplete(pos, "unexpected end of input after \\")
+			}
+			if l.peek() == '\n' {
+				l.advance()
+				continue
+			}
+			lit.WriteByte('\\')
+			lit.WriteByte(l.advance())
+		case '\'':
+			flush()
+			w.Parts = append(w.Parts, l.sglQuoted())
+		case '"':
+			flush()
+			w.Parts = append(w.Parts, l.dblQuoted())
+		case '`':
+			flush()
+			w.Parts = append(w.Parts, l.backquote())
+		case '$':
+			if part := l.dollar(); part != nil {
+				flush()
+				w.Parts = append(w.Parts, part)
+			} else {
+				lit.WriteByte('$')
+			}
+		default:
+			lit.WriteByte(l.advance())
+		}
+	}
+	flush()
+	return w
+}
+
+func (l *lexer) sglQuoted() *SglQuoted {
+	pos := l.pos()
+	l.advance()
+	start := l.off
+	for !l.eof() && l.peek() != '\'' {
+		l.advance()
+	}
+	if l.eof() {
+		l.failIncomplete(pos, "unterminated single quote")
+	}
+	v := l.src[start:l.off]
+	l.advance()
+	return &SglQuoted{Value: v}
+}
+
+func (l *lexer) dblQuoted() *DblQuoted {
+	pos := l.pos()
+	l.advance()
+	q := &DblQuoted{Parts: l.dblParts('"', pos)}
+	l.advance()
+	return q
+}
+
+// dblParts lexes double-quoted content up to the closing byte end, which is
+// left unconsumed; an end of 0 reads to the end of input.
+func (l *lexer) dblParts(end byte, pos Pos) []WordPart {
+	l.dquote++
+	defer func() { l.dquote-- }()
+	var parts []WordPart
+	lit := strings.Builder{}
+	flush := func() {
+		if lit.Len() > 0 {
+			parts = append(parts, &Lit{Value: lit.String()})
+			lit.Reset()
+		}
+	}
+	for {
+		if l.eof() {
+			if end == 0 {
+				flush()
+				return parts
+			}
+			l.failIncomplete(pos, "unterminated double quote")
+		}
+		c := l.peek()
+		switch {
+		case c == end:
+			flush()
+			return parts
+		case c == '\\':
+			l.advance()
+			if l.eof() {
+				l.failIncomplete(pos, "unterminated double quote")
+			}
+			if l.peek() == '\n' {
+				l.advance()
+				continue
+			}
+			if l.heredoc && l.peek() == '"' {
+				lit.WriteString(`\\`)
+			}
+			lit.WriteByte('\\')
+			lit.WriteByte(l.advance())
+		case c == '$':
+			if part := l.dollar(); part != nil {
+				flush()
+				parts = append(parts, part)
+			} else {
+				lit.WriteByte('$')
+			}
+		case c == '`':
+			flush()
+			parts = append(parts, l.backquote())
+		default:
+			lit.WriteByte(l.advance())
+		}
+	}
+}
+
+// dollar consumes a parameter expansion starting at '$'. A '$' that does not
+// start an expansion is consumed and nil is returned so it stays literal.
+func (l *lexer) dollar() WordPart {
+	pos := l.pos()
+	l.advance()
+	c := l.peek()
+	switch {
+	case c == '(' && l.peekAt(1) == '(':
+		return l.arith(pos)
+	case c == '(':
+		l.advance()
+		dquote := l.dquote
+		l.dquote = 0
+		defer func() { l.dquote = dquote }()
+		p := &parser{lx: l}
+		p.next()
+		list := p.list()
+		if !p.isOp(")") {
+			p.unexpected()
+		}
+		return &CmdSubst{List: list}
+	case c == '{':
+		l.advance()
+		return l.braced(pos)
+	case isNameStart(c):
+		start := l.off
+		for !l.eof() && isAlnumUnderscore(l.peek()) {
+			l.advance()
+		}
+		return &ParamExp{Name: l.src[start:l.off]}
+	case isSpecialParam(c):
+		l.advance()
+		return &ParamExp{Name: string(c)}
+	}
+	return nil
+}
+
+// braced lexes the rest of ${...} after the brace: ${#name}, a subscript
+// and an operator with its word.
+func (l *lexer) braced(pos Pos) *ParamExp {
+	pe := &ParamExp{Braced: true}
+	start := l.off
+	if l.peek() == '#' && l.peekAt(1) != '}' && l.peekAt(1) != ':' && paramStart(l.peekAt(1)) {
+		pe.Length = true
+		l.advance()
+	}
+	c := l.peek()
+	switch {
+	case isNameStart(c):
+		for !l.eof() && isAlnumUnderscore(l.peek()) {
+			l.advance()
+		}
+	case c >= '0' && c <= '9':
+		for !l.eof() && l.peek() >= '0' && l.peek() <= '9' {
+			l.advance()
+		}
+	case isSpecialParam(c):
+		l.advance()
+	default:
+		l.badSubst(pos, start)
+	}
+	pe.Name = l.src[start:l.off]
+	if pe.Length {
+		pe.Name = pe.Name[1:]
+	}
+	if l.peek() == '[' && isName(pe.Name) {
+		pe.Index = l.subscript(pos)
+	}
+	if l.eof() {
+		l.failIncomplete(pos, "unterminated ${")
+	}
+	if l.peek() == '}' {
+		l.advance()
+		return pe
+	}
+	if pe.Length {
+		l.badSubst(pos, start)
+	}
+	opStart := l.off
+	switch c := l.advance(); {
+	case c == ':' && strings.IndexByte("-=?+", l.peek()) >= 0:
+		l.advance()
+	case strings.IndexByte("-=?+", c) >= 0:
+	case c == '#' || c == '%':
+		if l.peek() == c {
+			l.advance()
+		}
+	case c == '/':
+		if strings.IndexByte("/#%", l.peek()) >= 0 {
+			l.advance()
+		}
+	default:
+		l.badSubst(pos, start)
+	}
+	pe.Op = l.src[opStart:l.off]
+	// Quotes in the pattern of # % and / always quote, as in bash.
+	pattern := strings.IndexByte("#%/", pe.Op[0]) >= 0
+	if pe.Op[0] == '/' {
+		pe.Arg = l.paramWord(pos, "/}", true)
+		if l.peek() == '/' {
+			l.advance()
+			pe.Repl = l.paramWord(pos, "}", false)
+		}
+	} else {
+		pe.Arg = l.paramWord(pos, "}", pattern)
+	}
+	l.advance()
+	return pe
+}
+
+// paramStart reports whether c can start the name of a parameter.
+func paramStart(c byte) bool {
+	return isNameStart(c) || isSpecialParam(c)
+}
+
+// badSubst reports the ${...} at pos whose text starts at offset start.
+func (l *lexer) badSubst(pos Pos, start int) {
+	for !l.eof() && l.peek() != '}' {
+		l.advance()
+	}
+	if l.eof() {
+		l.failIncomplete(pos, "unterminated ${")
+	}
+	l.fail(pos, "bad substitution ${"+l.src[start:l.off]+"}")
+}
+
+// subscript lexes the [index] of ${name[index]}, which is lexed like the
+// inside of $((...)).
+func (l *lexer) subscript(pos Pos) *Word {
+	l.advance()
+	start, startPos := l.off, l.pos()
+	depth := 0
+	for {
+		if l.eof() {
+			l.failIncomplete(pos, "unterminated ${")
+		}
+		c := l.peek()
+		if c == ']' && depth == 0 {
+			break
+		}
+		if c == '[' {
+			depth++
+		} else if c == ']' {
+			depth--
+		}
+		l.advance()
+	}
+	if l.off == start {
+		l.badSubst(pos, start)
+	}
+	sub := &lexer{src: l.src[start:l.off], line: startPos.Line, col: startPos.Col}
+	l.advance()
+	return &Word{Pos: startPos, Parts: sub.dblParts(0, pos)}
+}
+
+// paramWord lexes the word of a ${name op word} up to one of the bytes in
+// stop, which is left unconsumed. Braces nest and blanks are part of the
+// word. Single quotes quote unless the expansion is double-quoted and
+// pattern is not set.
+func (l *lexer) paramWord(pos Pos, stop string, pattern bool) *Word {
+	w := &Word{Pos: l.pos()}
+	lit := strings.Builder{}
+	flush := func() {
+		if lit.Len() > 0 {
+			w.Parts = append(w.Parts, &Lit{Value: lit.String()})
+			lit.Reset()
+		}
+	}
+	depth := 0
+	for {
+		if l.eof() {
+			l.failIncomplete(pos, "unterminated ${")
+		}
+		c := l.peek()
+		if depth == 0 && strings.IndexByte(stop, c) >= 0 {
+			flush()
+			return w
+		}
+		switch {
+		case c == '\\':
+			l.advance()
+			if l.eof() {
+				l.failIncomplete(pos, "unterminated ${")
+			}
+			if l.peek() == '\n' {
+				l.advance()
+				continue
+			}
+			lit.WriteByte('\\')
+			lit.WriteByte(l.advance())
+		case c == '\'' && (l.dquote == 0 || pattern):
+			flush()
+			w.Parts = append(w.Parts, l.sglQuoted())
+		case c == '"':
+			flush()
+			w.Parts = append(w.Parts, l.dblQuoted())
+		case c == '`':
+			flush()
+			w.Parts = append(w.Parts, l.backquote())
+		case c == '$':
+			if part := l.dollar(); part != nil {
+				flush()
+				w.Parts = append(w.Parts, part)
+			} else {
+				lit.WriteByte('$')
+			}
+		default:
+			if c == '{' {
+				depth++
+			} else if c == '}' {
+				depth--
+			}
+			lit.WriteByte(l.advance())
+		}
+	}
+}
+
+func isNameStart(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_'
+}
+
+func isAlnumUnderscore(b byte) bool {
+	if (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9') || b == '_' {
+		return true
+	}
+	return false
+}
+
+func isSpecialParam(c byte) bool {
+	return (c >= '0' && c <= '9') || strings.IndexByte("?$!#@*-", c) >= 0
+}
+
+func isName(s string) bool {
+	if s == "" || !isNameStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isAlnumUnderscore(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *lexer) arith(pos Pos) *ArithExp {
+	l.advance()
+	l.advance()
+	start, startPos := l.off, l.pos()
+	depth := 0
+	for {
+		if l.eof() {
+			l.failIncomplete(pos, "unterminated $((")
+		}
+		c := l.peek()
+		if c == ')' && depth == 0 && l.peekAt(1) == ')' {
+			break
+		}
+		if c == '(' {
+			depth++
+		} else if c == ')' {
+			depth--
+		}
+		l.advance()
+	}
+	sub := &lexer{src: l.src[start:l.off], line: startPos.Line, col: startPos.Col}
+	l.advance()
+	l.advance()
+	return &ArithExp{Parts: sub.dblParts(0, pos)}
+}
+
+// splice replaces the source from pos to end with the text of alias name and
+// moves back to pos to lex the replacement.
+func (l *lexer) splice(pos Pos, end int, name, text string) {
+	l.src = l.src[:pos.Offset] + text + l.src[end:]
+	delta := len(text) - (end - pos.Offset)
+	for i := range l.aliases {
+		if l.aliases[i].end >= end {
+			l.aliases[i].end += delta
+		}
+	}
+	l.aliases = append(l.aliases, aliasSpan{name: name, end: pos.Offset + len(text)})
+	l.off, l.line, l.col = pos.Offset, pos.Line, pos.Col
+}
+
+// inAlias reports whether offset off lies in a replacement of alias name.
+func (l *lexer) inAlias(name string, off int) bool {
+	for _, s := range l.aliases {
+		if s.name == name && off < s.end {
+			return true
+		}
+	}
+	return false
+}
+
+// hereDoc reads the body of the here-document r up to the line holding only
+// its delimiter. Leading tabs are stripped for <<-. The body is expanded
+// like a double-quoted string unless any part of the delimiter is quoted.
+func (l *lexer) hereDoc(r *Redirect) {
+	delim, quoted := hereDocDelim(r.Target)
+	pos := l.pos()
+	body := strings.Builder{}
+	for {
+		if l.eof() {
+			l.failIncomplete(r.Pos, "unterminated here-document, expecting `"+delim+"'")
+		}
+		start := l.off
+		for !l.eof() && l.peek() != '\n' {
+			l.advance()
+		}
+		line := l.src[start:l.off]
+		if !l.eof() {
+			l.advance()
+		}
+		if r.Op == "<<-" {
+			line = strings.TrimLeft(line, "\t")
+		}
+		if line == delim {
+			break
+		}
+		body.WriteString(line)
+		body.WriteByte('\n')
+	}
+	r.Raw = body.String()
+	if quoted {
+		r.Doc = &Word{Pos: pos, Parts: []WordPart{&SglQuoted{Value: body.String()}}}
+		return
+	}
+	sub := &lexer{src: body.String(), line: pos.Line, col: pos.Col, heredoc: true}
+	r.Doc = &Word{Pos: pos, Parts: []WordPart{&DblQuoted{Parts: sub.dblParts(0, pos)}}}
+}
+
+// hereDocDelim returns the delimiter spelled by w after quote removal and
+// whether any of it was quoted.
+func hereDocDelim(w *Word) (string, bool) {
+	delim := strings.Builder{}
+	quoted := false
+	for _, part := range w.Parts {
+		switch part := part.(type) {
+		case *Lit:
+			if strings.IndexByte(part.Value, '\\') >= 0 {
+				quoted = true
+			}
+			delim.WriteString(unescape(part.Value, ""))
+		case *SglQuoted:
+			quoted = true
+			delim.WriteString(part.Value)
+		case *DblQuoted:
+			quoted = true
+			for _, inner := range part.Parts {
+				if lit, ok := inner.(*Lit); ok {
+					delim.WriteString(unescape(lit.Value, "$`\"\\"))
+				}
+			}
+		case *ParamExp:
+			if part.Braced {
+				delim.WriteString("${" + part.Name + "}")
+			} else {
+				delim.WriteString("$" + part.Name)
+			}
+		}
+	}
+	return delim.String(), quoted
+}
+
+// backquote lexes the old-style `list` command substitution. Its body is
+// unescaped first and then parsed on its own.
+func (l *lexer) backquote() *CmdSubst {
+	pos := l.pos()
+	l.advance()
+	body := strings.Builder{}
+	for {
+		if l.eof() {
+			l.failIncomplete(pos, "unterminated backquote")
+		}
+		c := l.advance()
+		if c == '`' {
+			break
+		}
+		if c == '\\' && strings.IndexByte("$`\\", l.peek()) >= 0 && !l.eof() {
+			c = l.advance()
+		}
+		body.WriteByte(c)
+	}
+	p := &parser{lx: &lexer{src: body.String(), line: pos.Line, col: pos.Col + 1}}
+	p.next()
+	list := p.list()
+	if p.tok.kind != tokEOF {
+		p.unexpected()
+	}
+	return &CmdSubst{List: list, Backquote: true}
+}
@@ -0,0 +1,497 @@
+package interp
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unicode"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// errInterrupted is returned by readLine when the line is abandoned with
+// Ctrl-C.
+var errInterrupted = errors.New("interrupted")
+
+// lineEditor reads command lines from the terminal in raw mode, with emacs
+// style key bindings, history recall, reverse search and tab completion.
+type lineEditor struct {
+	fd  int
+	out *os.File
+	// hist is recalled with the arrow keys and searched with Ctrl-R;
+	// completions lists the candidates for the word before the cursor.
+	hist        *history
+	completions func(before, word string) []string
+
+	prompt  string // the whole prompt
+	last    string // its last line, redrawn on every change
+	buf     []rune
+	pos     int
+	yank    []rune
+	histIdx int
+	edited  []rune // the new line while browsing the history
+	lastTab bool
+}
+
+func ctrl(c rune) rune {
+	return c & 0x1f
+}
+
+// readLine reads one line, returned with its trailing newline. Ctrl-D on an
+// empty line gives io.EOF.
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	old, err := tcgetattr(e.fd)
+	if err != nil {
+		return "", err
+	}
+	raw := *old
+	raw.Iflag &^= syscall.ICRNL | syscall.IXON | syscall.BRKINT | syscall.INPCK | syscall.ISTRIP
+	raw.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := tcsetattr(e.fd, &raw); err != nil {
+		return "", err
+	}
+	defer tcsetattr(e.fd, old)
+
+	e.prompt, e.last = prompt, prompt
+	if i := strings.LastIndexByte(prompt, '\n'); i >= 0 {
+		e.last = prompt[i+1:]
+	}
+	e.buf, e.pos, e.edited = nil, 0, nil
+	e.histIdx = len(e.hist.entries)
+	e.lastTab = false
+	e.out.WriteString(prompt)
+
+	var pending rune
+	for {
+		r := pending
+		pending = 0
+		if r == 0 {
+			if r, err = e.readRune(); err != nil {
+				return "", err
+			}
+		}
+		tab := false
+		switch r {
+		case '\r', '\n':
+			e.pos = len(e.buf)
+			e.refresh()
+			e.out.WriteString("\r\n")
+			return string(e.buf) + "\n", nil
+		case ctrl('C'):
+			e.out.WriteString("^C\r\n")
+			return "", errInterrupted
+		case ctrl('D'):
+			if len(e.buf) == 0 {
+				return "", io.EOF
+			}
+			e.delete(e.pos, e.pos+1)
+		case ctrl('A'):
+			e.pos = 0
+		case ctrl('E'):
+			e.pos = len(e.buf)
+		case ctrl('B'):
+			e.move(-1)
+		case ctrl('F'):
+			e.move(1)
+		case ctrl('H'), 127:
+			e.delete(e.pos-1, e.pos)
+		case ctrl('K'):
+			e.kill(e.pos, len(e.buf))
+		case ctrl('U'):
+			e.kill(0, e.pos)
+		case ctrl('W'):
+			start := e.pos
+			for start > 0 && e.buf[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && e.buf[start-1] != ' ' {
+				start--
+			}
+			e.kill(start, e.pos)
+		case ctrl('Y'):
+			e.insert(e.yank...)
+		case ctrl('T'):
+			e.transpose()
+		case ctrl('L'):
+			e.out.WriteString("\x1b[H\x1b[2J" + e.prompt)
+		case ctrl('P'):
+			e.recall(e.histIdx - 1)
+		case ctrl('N'):
+			e.recall(e.histIdx + 1)
+		case ctrl('R'):
+			accept, next, err := e.search()
+			if err != nil {
+				return "", err
+			}
+			if accept {
+				pending = '\r'
+			} else {
+				pending = next
+			}
+		case '\t':
+			tab = true
+			e.complete()
+		case 27:
+			if err := e.escape(); err != nil {
+				return "", err
+			}
+		default:
+			if r >= ' ' {
+				e.insert(r)
+			}
+		}
+		e.lastTab = tab
+		e.refresh()
+	}
+}
+
+func (e *lineEditor) readRune() (rune, error) {
+	var b [utf8.UTFMax]byte
+	if err := e.readByte(b[:1]); err != nil {
+		return 0, err
+	}
+	n := 1
+	switch {
+	case b[0] >= 0xf0:
+		n = 4
+	case b[0] >= 0xe0:
+		n = 3
+	case b[0] >= 0xc0:
+		n = 2
+	}
+	for i := 1; i < n; i++ {
+		if err := e.readByte(b[i : i+1]); err != nil {
+			return 0, err
+		}
+	}
+	r, _ := utf8.DecodeRune(b[:n])
+	return r, nil
+}
+
+func (e *lineEditor) readByte(b []byte) error {
+	for {
+		n, err := syscall.Read(e.fd, b)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.EOF
+		}
+		return nil
+	}
+}
+
+// escape handles the key sequences that start with ESC: the cursor keys and
+// the meta bindings.
+func (e *lineEditor) escape() error {
+	r, err := e.readRune()
+	if err != nil {
+		return err
+	}
+	switch r {
+	case 'b', 'B':
+		e.pos = e.wordStart(e.pos)
+		return nil
+	case 'f', 'F':
+		e.pos = e.wordEnd(e.pos)
+		return nil
+	case 'd', 'D':
+		e.kill(e.pos, e.wordEnd(e.pos))
+		return nil
+	case 127, ctrl('H'):
+		e.kill(e.wordStart(e.pos), e.pos)
+		return nil
+	case '[', 'O':
+	default:
+		return nil
+	}
+	seq := []rune{}
+	for {
+		c, err := e.readRune()
+		if err != nil {
+			return err
+		}
+		seq = append(seq, c)
+		if c >= 0x40 && c <= 0x7e {
+			break
+		}
+	}
+	switch string(seq) {
+	case "A":
+		e.recall(e.histIdx - 1)
+	case "B":
+		e.recall(e.histIdx + 1)
+	case "C":
+		e.move(1)
+	case "D":
+		e.move(-1)
+	case "H", "1~", "7~":
+		e.pos = 0
+	case "F", "4~", "8~":
+		e.pos = len(e.buf)
+	case "3~":
+		e.delete(e.pos, e.pos+1)
+	case "1;5C", "1;3C":
+		e.pos = e.wordEnd(e.pos)
+	case "1;5D", "1;3D":
+		e.pos = e.wordStart(e.pos)
+	}
+	return nil
+}
+
+// refresh redraws the last line of the prompt and the buffer and puts the
+// cursor back in place.
+func (e *lineEditor) refresh() {
+	s := strings.Builder{}
+	s.WriteString("\r")
+	s.WriteString(e.last)
+	s.WriteString(string(e.buf))
+	s.WriteString("\x1b[K")
+	if n := len(e.buf) - e.pos; n > 0 {
+		s.WriteString("\x1b[" + strconv.Itoa(n) + "D")
+	}
+	e.out.WriteString(s.String())
+}
+
+func (e *lineEditor) move(n int) {
+	e.pos += n
+	if e.pos < 0 {
+		e.pos = 0
+	}
+	if e.pos > len(e.buf) {
+		e.pos = len(e.buf)
+	}
+}
+
+func (e *lineEditor) insert(rs ...rune) {
+	buf := make([]rune, 0, len(e.buf)+len(rs))
+	buf = append(buf, e.buf[:e.pos]...)
+	buf = append(buf, rs...)
+	e.buf = append(buf, e.buf[e.pos:]...)
+	e.pos += len(rs)
+}
+
+func (e *lineEditor) delete(from, to int) {
+	if from < 0 {
+		from = 0
+	}
+	if to > len(e.buf) {
+		to = len(e.buf)
+	}
+	if from >= to {
+		return
+	}
+	e.buf = append(e.buf[:from], e.buf[to:]...)
+	if e.pos > to {
+		e.pos -= to - from
+	} else if e.pos > from {
+		e.pos = from
+	}
+}
+
+// kill deletes buf[from:to] and keeps it for Ctrl-Y.
+func (e *lineEditor) kill(from, to int) {
+	if from >= to {
+		return
+	}
+	e.yank = append([]rune(nil), e.buf[from:to]...)
+	e.delete(from, to)
+}
+
+func (e *lineEditor) transpose() {
+	if len(e.buf) < 2 || e.pos == 0 {
+		return
+	}
+	if e.pos == len(e.buf) {
+		e.pos--
+	}
+	e.buf[e.pos-1], e.buf[e.pos] = e.buf[e.pos], e.buf[e.pos-1]
+	e.pos++
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func (e *lineEditor) wordStart(pos int) int {
+	for pos > 0 && !isWordRune(e.buf[pos-1]) {
+		pos--
+	}
+	for pos > 0 && isWordRune(e.buf[pos-1]) {
+		pos--
+	}
+	return pos
+}
+
+func (e *lineEditor) wordEnd(pos int) int {
+	for pos < len(e.buf) && !isWordRune(e.buf[pos]) {
+		pos++
+	}
+	for pos < len(e.buf) && isWordRune(e.buf[pos]) {
+		pos++
+	}
+	return pos
+}
+
+// recall replaces the buffer with history entry i; one past the last entry
+// is the line that was being typed before browsing started.
+func (e *lineEditor) recall(i int) {
+	if i < 0 || i > len(e.hist.entries) {
+		return
+	}
+	if e.histIdx == len(e.hist.entries) {
+		e.edited = append([]rune(nil), e.buf...)
+	}
+	e.histIdx = i
+	if i == len(e.hist.entries) {
+		e.buf = append([]rune(nil), e.edited...)
+	} else {
+		e.buf = []rune(e.hist.entries[i])
+	}
+	e.pos = len(e.buf)
+}
+
+// search runs an incremental reverse search through the history. It reports
+// whether the match was accepted with Enter; any other key that ends the
+// search is returned to be handled as usual.
+func (e *lineEditor) search() (bool, rune, error) {
+	orig, origPos := e.buf, e.pos
+	var query []rune
+	match := len(e.hist.entries)
+	failed := false
+	find := func(from int) {
+		for i := from; i >= 0; i-- {
+			if i < len(e.hist.entries) && strings.Contains(e.hist.entries[i], string(query)) {
+				match, failed = i, false
+				return
+			}
+		}
+		failed = true
+	}
+	for {
+		line := ""
+		if match < len(e.hist.entries) {
+			line = e.hist.entries[match]
+		}
+		label := "(reverse-i-search)`"
+		if failed {
+			label = "(failed reverse-i-search)`"
+		}
+		e.out.WriteString("\r" + label + string(query) + "': " + line + "\x1b[K")
+
+		r, err := e.readRune()
+		if err != nil {
+			return false, 0, err
+		}
+		switch {
+		case r == ctrl('R'):
+			find(match - 1)
+		case r == ctrl('H') || r == 127:
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				find(len(e.hist.entries) - 1)
+			}
+		case r == ctrl('G') || r == ctrl('C'):
+			e.buf, e.pos = orig, origPos
+			return false, 0, nil
+		case r >= ' ' && r != 127:
+			query = append(query, r)
+			find(match)
+		default:
+			if match < len(e.hist.entries) {
+				e.buf = []rune(e.hist.entries[match])
+				e.pos = len(e.buf)
+				e.histIdx = match
+			}
+			if r == '\r' || r == '\n' {
+				return true, 0, nil
+			}
+			return false, r, nil
+		}
+	}
+}
+
+// complete completes the word before the cursor. A single candidate is
+// inserted whole; otherwise the longest common prefix is inserted, and a
+// second Tab in a row lists the candidates.
+func (e *lineEditor) complete() {
+	start := e.pos
+	for start > 0 && !(isCompletionBreak(e.buf[start-1]) && (start < 2 || e.buf[start-2] != '\\')) {
+		start--
+	}
+	word := string(e.buf[start:e.pos])
+	cands := e.completions(string(e.buf[:start]), word)
+	if len(cands) == 0 {
+		e.out.WriteString("\a")
+		return
+	}
+	prefix := cands[0]
+	for _, c := range cands[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(cands) == 1 && !strings.HasSuffix(prefix, "/") {
+		prefix += " "
+	}
+	if prefix != word && len(prefix) >= len(word) {
+		e.delete(start, e.pos)
+		e.insert([]rune(prefix)...)
+		return
+	}
+	if !e.lastTab {
+		e.out.WriteString("\a")
+		return
+	}
+	e.list(cands)
+}
+
+// list prints cands in columns under the line and redraws the prompt.
+func (e *lineEditor) list(cands []string) {
+	width := 0
+	for _, c := range cands {
+		if n := utf8.RuneCountInString(c); n > width {
+			width = n
+		}
+	}
+	width += 2
+	cols := termWidth() / width
+	if cols < 1 {
+		cols = 1
+	}
+	rows := (len(cands) + cols - 1) / cols
+	s := strings.Builder{}
+	s.WriteString("\r\n")
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			i := c*rows + r
+			if i >= len(cands) {
+				break
+			}
+			s.WriteString(cands[i])
+			if c < cols-1 && i+rows < len(cands) {
+				s.WriteString(strings.Repeat(" ", width-utf8.RuneCountInString(cands[i])))
+			}
+		}
+		s.WriteString("\r\n")
+	}
+	s.WriteString(e.prompt)
+	e.out.WriteString(s.String())
+}
+
+func termWidth() int {
+	var ws struct{ row, col, x, y uint16 }
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(1), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws)))
+	if errno != 0 || ws.col == 0 {
+		return 80
+	}
+	return int(ws.col)
+}
@@ -0,0 +1,207 @@
+package interp
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// shellOptions are the options set -o and set +o turn on and off; letter
+// is the single-letter flag of the option, if it has one.
+var shellOptions = []struct {
+	name   string
+	letter byte
+	on     func(r *Runner) *bool
+}{
+	{"errexit", 'e', func(r *Runner) *bool { return &r.optErrexit }},
+	{"nounset", 'u', func(r *Runner) *bool { return &r.optNounset }},
+	{"pipefail", 0, func(r *Runner) *bool { return &r.optPipefail }},
+	{"xtrace", 'x', func(r *Runner) *bool { return &r.optXtrace }},
+}
+
+func (r *Runner) setOption(name string, on bool) bool {
+	for _, o := range shellOptions {
+		if o.name == name {
+			*o.on(r) = on
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Runner) optionValues() []bool {
+	values := make([]bool, len(shellOptions))
+	for i, o := range shellOptions {
+		values[i] = *o.on(r)
+	}
+	return values
+}
+
+func (r *Runner) setOptionValues(values []bool) {
+	for i, o := range shellOptions {
+		*o.on(r) = values[i]
+	}
+}
+
+// flags returns the value of $-: the letters of the options that are on,
+// and i in an interactive shell.
+func (r *Runner) flags() string {
+	flags := ""
+	for _, o := range shellOptions {
+		if o.letter != 0 && *o.on(r) {
+			flags += string(o.letter)
+		}
+	}
+	if r.interactive {
+		flags += "i"
+	}
+	return flags
+}
+
+// trace writes the expanded command cmd to standard error after $PS4, for
+// set -x.
+func (r *Runner) trace(cmd *Cmd) {
+	var words []string
+	for _, kv := range cmd.Env {
+		name, value, _ := strings.Cut(kv, "=")
+		words = append(words, name+"="+shellQuote(value))
+	}
+	for _, aa := range cmd.arrays {
+		if !aa.whole {
+			words = append(words, fmt.Sprintf("%s[%d]=%s", aa.name, aa.index, shellQuote(aa.values[0])))
+			continue
+		}
+		words = append(words, aa.name+"=("+quoteArgs(aa.values)+")")
+	}
+	for _, arg := range cmd.Args {
+		words = append(words, shellQuote(arg))
+	}
+	fmt.Fprintln(r.stderr, r.ps4()+strings.Join(words, " "))
+}
+
+// ps4 expands $PS4, "+ " by default, with its first character repeated for
+// each command substitution the command runs in.
+func (r *Runner) ps4() string {
+	ps4, ok := r.getVar("PS4")
+	if !ok {
+		ps4 = "+ "
+	}
+	if strings.ContainsAny(ps4, "$`") {
+		savedTrace, savedNounset := r.optXtrace, r.optNounset
+		r.optXtrace, r.optNounset = false, false
+		if v, err := r.expandString(ps4); err == nil {
+			ps4 = v
+		}
+		r.optXtrace, r.optNounset = savedTrace, savedNounset
+	}
+	if ps4 == "" {
+		return ""
+	}
+	return strings.Repeat(ps4[:1], r.substDepth) + ps4
+}
+
+// errexit ends the shell with status if set -e is on and the command that
+// failed is not in a context that tests its status.
+func (r *Runner) errexit(status int) {
+	if r.optErrexit && status != 0 && r.errexitIgnored == 0 && !r.exiting {
+		r.exiting, r.exitCode = true, status
+	}
+}
+
+func (r *Runner) setFlag(letter byte, on bool) bool {
+	for _, o := range shellOptions {
+		if o.letter != 0 && o.letter == letter {
+			*o.on(r) = on
+			return true
+		}
+	}
+	return false
+}
+
+// builtinSet turns options on with -o name or -e, -u, -x and off with +o
+// name or +e, +u, +x,
+// lists them with set -o and set +o, and replaces the positional parameters
+// with the remaining arguments. With no arguments it prints the variables.
+func (r *Runner) builtinSet(cmd *Cmd) int {
+	args := cmd.Args[1:]
+	if len(args) == 0 {
+		names := make([]string, 0, len(r.vars))
+		for name := range r.vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(cmd.Stdout, "%s=%s\n", name, shellQuote(r.vars[name].value))
+		}
+		return 0
+	}
+	for len(args) > 0 {
+		arg := args[0]
+		switch {
+		case arg == "--":
+			r.positional = append([]string(nil), args[1:]...)
+			return 0
+		case arg == "-o" || arg == "+o":
+			if len(args) == 1 {
+				for _, o := range shellOptions {
+					switch {
+					case arg == "+o" && *o.on(r):
+						fmt.Fprintf(cmd.Stdout, "set -o %s\n", o.name)
+					case arg == "+o":
+						fmt.Fprintf(cmd.Stdout, "set +o %s\n", o.name)
+					case *o.on(r):
+						fmt.Fprintf(cmd.Stdout, "%-15s\ton\n", o.name)
+					default:
+						fmt.Fprintf(cmd.Stdout, "%-15s\toff\n", o.name)
+					}
+				}
+				return 0
+			}
+			if !r.setOption(args[1], arg == "-o") {
+				fmt.Fprintf(cmd.Stderr, "set: %s: invalid option name\n", args[1])
+				return 2
+			}
+			args = args[2:]
+		case len(arg) > 1 && (arg[0] == '-' || arg[0] == '+'):
+			for i := 1; i < len(arg); i++ {
+				if !r.setFlag(arg[i], arg[0] == '-') {
+					fmt.Fprintf(cmd.Stderr, "set: %c%c: invalid option\n", arg[0], arg[i])
+					return 2
+				}
+			}
+			args = args[1:]
+		default:
+			r.positional = append([]string(nil), args...)
+			return 0
+		}
+	}
+	return 0
+}
+
+// builtinShift drops the first n positional parameters, one by default.
+// Like bash, it fails quietly when there are fewer than n.
+func (r *Runner) builtinShift(cmd *Cmd) int {
+	n := 1
+	switch len(cmd.Args) {
+	case 1:
+	case 2:
+		var err error
+		if n, err = strconv.Atoi(cmd.Args[1]); err != nil {
+			fmt.Fprintf(cmd.Stderr, "shift: %s: numeric argument required\n", cmd.Args[1])
+			return 1
+		}
+		if n < 0 {
+			fmt.Fprintf(cmd.Stderr, "shift: %s: shift count out of range\n", cmd.Args[1])
+			return 1
+		}
+	default:
+		fmt.Fprintln(cmd.Stderr, "shift: too many arguments")
+		return 1
+	}
+	if n > len(r.positional) {
+		return 1
+	}
+	r.positional = r.positional[n:]
+	return 0
+}
@@ -0,0 +1,612 @@
+package interp
+
+import "strings"
+
+type parser struct {
+	lx  *lexer
+	tok token
+	end int
+	// aliases are expanded in command position.
+	aliases map[string]string
+	// recovering makes a syntax error in a command skip the rest of its
+	// line and be collected in errs, so that one pass finds them all.
+	recovering bool
+	errs       []*SyntaxError
+}
+
+func parseLine(line string) (*List, error) {
+	return parseWithAliases(line, nil)
+}
+
+func parseWithAliases(line string, aliases map[string]string) (*List, error) {
+	p := &parser{lx: newLexer(line), aliases: aliases}
+	return p.parse()
+}
+
+// Check parses src without running it and returns every syntax error in
+// it, in order. After an error the rest of the line is skipped; an error
+// at the end of input, such as a missing fi, is always the last.
+func Check(src string) (errs []*SyntaxError) {
+	p := &parser{lx: newLexer(src), recovering: true}
+	defer func() {
+		if e := recover(); e != nil {
+			se, ok := e.(*SyntaxError)
+			if !ok {
+				panic(e)
+			}
+			errs = append(p.errs, se)
+		}
+	}()
+	p.next()
+	for {
+		p.list()
+		if p.tok.kind == tokEOF {
+			return p.errs
+		}
+		p.recoverLine(p.unexpected)
+	}
+}
+
+func (p *parser) parse() (list *List, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			se, ok := e.(*SyntaxError)
+			if !ok {
+				panic(e)
+			}
+			list, err = nil, se
+		}
+	}()
+	p.next()
+	list = p.list()
+	if p.tok.kind != tokEOF {
+		p.unexpected()
+	}
+	return list, nil
+}
+
+// recoverLine runs fn, and if it fails with a syntax error that more input
+// would not fix, records the error and skips to the end of the line.
+func (p *parser) recoverLine(fn func()) (ok bool) {
+	defer func() {
+		if e := recover(); e != nil {
+			se, isSyntax := e.(*SyntaxError)
+			if !isSyntax || se.Incomplete {
+				panic(e)
+			}
+			p.errs = append(p.errs, se)
+			for p.tok.kind != tokNewline && p.tok.kind != tokEOF {
+				p.next()
+			}
+		}
+	}()
+	fn()
+	return true
+}
+
+func (p *parser) next() {
+	p.end = p.tok.end
+	p.tok = p.lx.next()
+}
+
+func (p *parser) isOp(op string) bool {
+	return p.tok.kind == tokOp && p.tok.op == op
+}
+
+func (p *parser) unexpected() {
+	if p.tok.kind == tokEOF {
+		p.lx.failIncomplete(p.tok.pos, "unexpected end of input")
+	}
+	p.lx.fail(p.tok.pos, "unexpected token "+p.tok.String())
+}
+
+func (p *parser) skipNewlines() {
+	for p.tok.kind == tokNewline {
+		p.next()
+	}
+}
+
+func (p *parser) text(start int) string {
+	return strings.TrimSpace(p.lx.src[start:p.end])
+}
+
+// list parses and-or chains up to the end of input or the first token that
+// cannot start a command.
+func (p *parser) list() *List {
+	l := &List{}
+	p.skipNewlines()
+	for p.startsCommand() {
+		var ao *AndOr
+		if !p.recovering {
+			ao = p.andOr()
+		} else if !p.recoverLine(func() { ao = p.andOr() }) {
+			p.skipNewlines()
+			continue
+		}
+		l.Items = append(l.Items, ao)
+		switch {
+		case p.isOp("&"):
+			ao.Background = true
+			p.next()
+		case p.isOp(";"):
+			p.next()
+		case p.tok.kind == tokNewline:
+		default:
+			return l
+		}
+		p.skipNewlines()
+	}
+	return l
+}
+
+// compoundList parses the list of a compound command, which must hold at
+// least one command.
+func (p *parser) compoundList() *List {
+	l := p.list()
+	if len(l.Items) == 0 {
+		p.unexpected()
+	}
+	return l
+}
+
+func (p *parser) startsCommand() bool {
+	switch p.tok.kind {
+	case tokWord:
+		return !p.isTerminator()
+	case tokOp:
+		return p.tok.op == "(" || isRedirOp(p.tok.op)
+	}
+	return false
+}
+
+func (p *parser) andOr() *AndOr {
+	ao := &AndOr{Pos: p.tok.pos}
+	ao.Pipelines = append(ao.Pipelines, p.pipeline())
+	for p.isOp("&&") || p.isOp("||") {
+		ao.Ops = append(ao.Ops, p.tok.op)
+		p.next()
+		p.skipNewlines()
+		ao.Pipelines = append(ao.Pipelines, p.pipeline())
+	}
+	ao.Text = p.text(ao.Pos.Offset)
+	return ao
+}
+
+func (p *parser) pipeline() *Pipeline {
+	pl := &Pipeline{Pos: p.tok.pos}
+	if p.isKeyword("time") {
+		pl.Time = true
+		p.next()
+		if p.isKeyword("-p") {
+			pl.TimePosix = true
+			p.next()
+		}
+	}
+	if p.tok.kind == tokWord && wordIsLit(p.tok.word, "!") {
+		pl.Bang = true
+		p.next()
+	}
+	pl.Cmds = append(pl.Cmds, p.command())
+	for p.isOp("|") {
+		p.next()
+		p.skipNewlines()
+		pl.Cmds = append(pl.Cmds, p.command())
+	}
+	pl.Text = p.text(pl.Pos.Offset)
+	return pl
+}
+
+// isTerminator reports whether the current token is a reserved word that
+// closes a compound command and therefore cannot start one.
+func (p *parser) isTerminator() bool {
+	for _, kw := range []string{"then", "elif", "else", "fi", "do", "done", "esac", "}"} {
+		if p.isKeyword(kw) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) isKeyword(kw string) bool {
+	return p.tok.kind == tokWord && wordIsLit(p.tok.word, kw)
+}
+
+func (p *parser) expectKeyword(kw string) {
+	if !p.isKeyword(kw) {
+		if p.tok.kind == tokEOF {
+			p.lx.failIncomplete(p.tok.pos, "unexpected end of input, expecting `"+kw+"'")
+		}
+		p.lx.fail(p.tok.pos, "unexpected token "+p.tok.String()+", expecting `"+kw+"'")
+	}
+	p.next()
+}
+
+func (p *parser) expectOp(op string) {
+	if !p.isOp(op) {
+		if p.tok.kind == tokEOF {
+			p.lx.failIncomplete(p.tok.pos, "unexpected end of input, expecting `"+op+"'")
+		}
+		p.lx.fail(p.tok.pos, "unexpected token "+p.tok.String()+", expecting `"+op+"'")
+	}
+	p.next()
+}
+
+func (p *parser) command() Command {
+	p.expandAlias()
+	if !p.startsCommand() {
+		p.unexpected()
+	}
+	var c Command
+	switch {
+	case p.isOp("("):
+		c = p.subshell()
+	case p.isKeyword("{"):
+		c = p.braceGroup()
+	case p.isKeyword("if"):
+		c = p.ifClause()
+	case p.isKeyword("while"), p.isKeyword("until"):
+		c = p.whileClause()
+	case p.isKeyword("for"):
+		c = p.forClause()
+	case p.isKeyword("case"):
+		c = p.caseClause()
+	case p.isKeyword("coproc"):
+		c = p.coproc()
+	}
+	if c != nil {
+		return p.compoundRedirs(c)
+	}
+	sc := p.simpleCommand()
+	if p.isOp("(") && len(sc.Args) == 1 && len(sc.Assigns) == 0 && len(sc.Redirs) == 0 {
+		return p.funcDecl(sc)
+	}
+	return sc
+}
+
+// expandAlias replaces the current word with the text of the alias it
+// names, if any, and reports whether it did.
+func (p *parser) expandAlias() bool {
+	expanded := false
+	for p.tok.kind == tokWord && len(p.tok.word.Parts) == 1 {
+		lit, ok := p.tok.word.Parts[0].(*Lit)
+		if !ok {
+			break
+		}
+		text, ok := p.aliases[lit.Value]
+		if !ok || p.lx.inAlias(lit.Value, p.tok.pos.Offset) {
+			break
+		}
+		p.lx.splice(p.tok.pos, p.tok.end, lit.Value, text)
+		p.tok = p.lx.next()
+		expanded = true
+	}
+	return expanded
+}
+
+func (p *parser) braceGroup() *BraceGroup {
+	g := &BraceGroup{Pos: p.tok.pos}
+	p.next()
+	g.List = p.compoundList()
+	p.expectKeyword("}")
+	g.Text = p.text(g.Pos.Offset)
+	return g
+}
+
+func (p *parser) subshell() *Subshell {
+	s := &Subshell{Pos: p.tok.pos}
+	p.next()
+	s.List = p.compoundList()
+	p.expectOp(")")
+	s.Text = p.text(s.Pos.Offset)
+	return s
+}
+
+// compoundRedirs wraps c in a Redirected if redirections follow it.
+func (p *parser) compoundRedirs(c Command) Command {
+	if p.tok.kind != tokOp || !isRedirOp(p.tok.op) {
+		return c
+	}
+	pos := commandPos(c)
+	r := &Redirected{Pos: pos, Cmd: c}
+	for p.tok.kind == tokOp && isRedirOp(p.tok.op) {
+		r.Redirs = append(r.Redirs, p.redirect())
+	}
+	r.Text = p.text(pos.Offset)
+	return r
+}
+
+// funcDecl parses the rest of a function definition whose name was read as
+// the simple command sc.
+func (p *parser) funcDecl(sc *SimpleCommand) *FuncDecl {
+	name := ""
+	if lit, ok := sc.Args[0].Parts[0].(*Lit); ok && len(sc.Args[0].Parts) == 1 && validAliasName(lit.Value) {
+		name = lit.Value
+	}
+	if name == "" || isReserved(name) {
+		p.lx.fail(sc.Pos, "invalid function name")
+	}
+	p.next()
+	p.expectOp(")")
+	p.skipNewlines()
+	f := &FuncDecl{Pos: sc.Pos, Name: name}
+	f.Body = p.command()
+	if _, simple := f.Body.(*SimpleCommand); simple {
+		p.lx.fail(sc.Pos, "function body must be a compound command")
+	}
+	f.Text = p.text(f.Pos.Offset)
+	return f
+}
+
+func isReserved(s string) bool {
+	switch s {
+	case "if", "then", "elif", "else", "fi", "while", "until", "for", "do", "done", "case", "esac", "in", "{", "}", "!", "coproc", "time":
+		return true
+	}
+	return false
+}
+
+// coproc parses coproc [name] command. As in bash, a name is only taken
+// before a compound command; otherwise the coprocess is called COPROC.
+func (p *parser) coproc() *Coproc {
+	c := &Coproc{Pos: p.tok.pos, Name: "COPROC"}
+	p.next()
+	if p.tok.kind == tokWord && len(p.tok.word.Parts) == 1 {
+		lit, ok := p.tok.word.Parts[0].(*Lit)
+		if ok && isName(lit.Value) && !isReserved(lit.Value) && p.compoundFollows() {
+			c.Name = lit.Value
+			p.next()
+		}
+	}
+	c.Cmd = p.command()
+	c.Text = p.text(c.Pos.Offset)
+	return c
+}
+
+// compoundFollows looks past the current token and reports whether the
+// next one starts a compound command.
+func (p *parser) compoundFollows() bool {
+	lx, tok, end := *p.lx, p.tok, p.end
+	p.next()
+	compound := p.isOp("(")
+	for _, kw := range []string{"{", "if", "while", "until", "for", "case"} {
+		compound = compound || p.isKeyword(kw)
+	}
+	*p.lx, p.tok, p.end = lx, tok, end
+	return compound
+}
+
+func (p *parser) ifClause() *IfClause {
+	c := &IfClause{Pos: p.tok.pos}
+	p.next()
+	c.Cond = p.compoundList()
+	p.expectKeyword("then")
+	c.Then = p.compoundList()
+	for p.isKeyword("elif") {
+		p.next()
+		e := &Elif{Cond: p.compoundList()}
+		p.expectKeyword("then")
+		e.Then = p.compoundList()
+		c.Elifs = append(c.Elifs, e)
+	}
+	if p.isKeyword("else") {
+		p.next()
+		c.Else = p.compoundList()
+	}
+	p.expectKeyword("fi")
+	c.Text = p.text(c.Pos.Offset)
+	return c
+}
+
+func (p *parser) whileClause() *WhileClause {
+	c := &WhileClause{Pos: p.tok.pos, Until: p.isKeyword("until")}
+	p.next()
+	c.Cond = p.compoundList()
+	p.expectKeyword("do")
+	c.Body = p.compoundList()
+	p.expectKeyword("done")
+	c.Text = p.text(c.Pos.Offset)
+	return c
+}
+
+func (p *parser) forClause() *ForClause {
+	c := &ForClause{Pos: p.tok.pos}
+	p.next()
+	if p.tok.kind != tokWord || len(p.tok.word.Parts) != 1 {
+		p.unexpected()
+	}
+	lit, ok := p.tok.word.Parts[0].(*Lit)
+	if !ok || !isName(lit.Value) {
+		p.lx.fail(p.tok.pos, "invalid for loop variable")
+	}
+	c.Name = lit.Value
+	p.next()
+	p.skipNewlines()
+	if p.isKeyword("in") {
+		c.InSet = true
+		p.next()
+		for p.tok.kind == tokWord {
+			c.Items = append(c.Items, p.tok.word)
+			p.next()
+		}
+		if p.isOp(";") || p.tok.kind == tokNewline {
+			p.next()
+		} else if p.tok.kind != tokEOF {
+			p.unexpected()
+		}
+	} else if p.isOp(";") {
+		p.next()
+	}
+	p.skipNewlines()
+	p.expectKeyword("do")
+	c.Body = p.compoundList()
+	p.expectKeyword("done")
+	c.Text = p.text(c.Pos.Offset)
+	return c
+}
+
+func (p *parser) caseClause() *CaseClause {
+	c := &CaseClause{Pos: p.tok.pos}
+	p.next()
+	if p.tok.kind != tokWord {
+		p.unexpected()
+	}
+	c.Word = p.tok.word
+	p.next()
+	p.skipNewlines()
+	p.expectKeyword("in")
+	p.skipNewlines()
+	for !p.isKeyword("esac") {
+		item := &CaseItem{}
+		if p.isOp("(") {
+			p.next()
+		}
+		for {
+			if p.tok.kind != tokWord {
+				p.unexpected()
+			}
+			item.Patterns = append(item.Patterns, p.tok.word)
+			p.next()
+			if !p.isOp("|") {
+				break
+			}
+			p.next()
+		}
+		p.expectOp(")")
+		item.Body = p.list()
+		c.Items = append(c.Items, item)
+		if !p.isOp(";;") {
+			break
+		}
+		p.next()
+		p.skipNewlines()
+	}
+	p.expectKeyword("esac")
+	c.Text = p.text(c.Pos.Offset)
+	return c
+}
+
+func (p *parser) simpleCommand() *SimpleCommand {
+	sc := &SimpleCommand{Pos: p.tok.pos}
+	for {
+		switch {
+		case p.tok.kind == tokWord:
+			if a := splitAssign(p.tok.word); a != nil && len(sc.Args) == 0 {
+				sc.Assigns = append(sc.Assigns, a)
+				end := p.tok.end
+				p.next()
+				if a.Index == nil && len(a.Value.Parts) == 0 && p.isOp("(") && p.tok.pos.Offset == end {
+					a.Array = p.arrayLit()
+				}
+				continue
+			} else if len(sc.Args) == 0 && len(sc.Assigns) > 0 && p.expandAlias() {
+				continue
+			} else {
+				sc.Args = append(sc.Args, p.tok.word)
+			}
+			p.next()
+		case p.tok.kind == tokOp && isRedirOp(p.tok.op):
+			sc.Redirs = append(sc.Redirs, p.redirect())
+		default:
+			return sc
+		}
+	}
+}
+
+func (p *parser) redirect() *Redirect {
+	r := &Redirect{Pos: p.tok.pos, Fd: p.tok.fd, Op: p.tok.op}
+	p.next()
+	if p.tok.kind != tokWord {
+		p.unexpected()
+	}
+	r.Target = p.tok.word
+	if r.Op == "<<" || r.Op == "<<-" {
+		p.lx.heredocs = append(p.lx.heredocs, r)
+	}
+	p.next()
+	return r
+}
+
+func isRedirOp(op string) bool {
+	switch op {
+	case "<", ">", ">>", ">|", "<>", "<&", ">&", "&>", "&>>", "<<", "<<-", "<<<":
+		return true
+	}
+	return false
+}
+
+// wordIsLit reports whether w is exactly the unquoted text s.
+func wordIsLit(w *Word, s string) bool {
+	if len(w.Parts) != 1 {
+		return false
+	}
+	lit, ok := w.Parts[0].(*Lit)
+	return ok && lit.Value == s
+}
+
+// arrayLit parses the (elements) of NAME=(elements), which may span lines.
+func (p *parser) arrayLit() *ArrayLit {
+	a := &ArrayLit{}
+	p.next()
+	p.skipNewlines()
+	for p.tok.kind == tokWord {
+		a.Elems = append(a.Elems, p.tok.word)
+		p.next()
+		p.skipNewlines()
+	}
+	if !p.isOp(")") {
+		p.unexpected()
+	}
+	p.next()
+	return a
+}
+
+// splitAssign returns the assignment spelled by w, as in NAME=value or
+// NAME[index]=value, or nil when w is an ordinary word.
+func splitAssign(w *Word) *Assign {
+	if len(w.Parts) == 0 {
+		return nil
+	}
+	lit, ok := w.Parts[0].(*Lit)
+	if !ok {
+		return nil
+	}
+	name, rest, found := strings.Cut(lit.Value, "=")
+	if found && isName(name) {
+		return &Assign{Pos: w.Pos, Name: name, Value: wordFrom(w.Pos, rest, w.Parts[1:])}
+	}
+	name, sub, found := strings.Cut(lit.Value, "[")
+	if !found || !isName(name) {
+		return nil
+	}
+	// The subscript runs up to "]=", which may come after other parts.
+	index := &Word{Pos: w.Pos}
+	parts := append([]WordPart{&Lit{Value: sub}}, w.Parts[1:]...)
+	for i, part := range parts {
+		lit, ok := part.(*Lit)
+		if !ok {
+			index.Parts = append(index.Parts, part)
+			continue
+		}
+		sub, rest, found := strings.Cut(lit.Value, "]=")
+		if sub != "" {
+			index.Parts = append(index.Parts, &Lit{Value: sub})
+		}
+		if found {
+			if len(index.Parts) == 0 {
+				return nil
+			}
+			return &Assign{Pos: w.Pos, Name: name, Index: index, Value: wordFrom(w.Pos, rest, parts[i+1:])}
+		}
+	}
+	return nil
+}
+
+// wordFrom makes a word of the literal text lit followed by parts.
+func wordFrom(pos Pos, lit string, parts []WordPart) *Word {
+	w := &Word{Pos: pos}
+	if lit != "" {
+		w.Parts = append(w.Parts, &Lit{Value: lit})
+	}
+	w.Parts = append(w.Parts, parts...)
+	return w
+}
@@ -0,0 +1,90 @@
+package interp
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		input      string
+		items      int
+		pos        string
+		incomplete bool
+	}{
+		{"echo hi", 1, "", false},
+		{"a | b && c || d &", 1, "", false},
+		{"a; b\nc", 3, "", false},
+		{"x=1 y=2 cmd arg >out 2>&1", 1, "", false},
+		{"f() { echo; }", 1, "", false},
+		{"if true; then echo; fi", 1, "", false},
+		{"(cd /tmp; ls) >out", 1, "", false},
+		{"diff <(sort a) <(sort b) >(cat)", 1, "", false},
+		{"coproc cat; coproc UP { cat; }", 2, "", false},
+		{"a=(x y\nz) b[1]=c; echo ${a[@]} ${#a} ${x:-a b} ${x/a/}", 2, "", false},
+		{"time -p a | b; time ! c && d", 2, "", false},
+		{"", 0, "", false},
+		{"echo )", 0, "1:6", false},
+		{"fi", 0, "1:1", false},
+		{"( )", 0, "1:3", false},
+		{"{ }", 0, "1:3", false},
+		{"if true; then fi", 0, "1:15", false},
+		{"for x in; do\ndone", 0, "2:1", false},
+		{"while do :; done", 0, "1:7", false},
+		{"echo ok\necho ;;", 0, "2:6", false},
+		{"if true; then", 0, "1:14", true},
+		{"a |", 0, "1:4", true},
+		{"echo >", 0, "1:7", true},
+		{"echo \"abc", 0, "1:6", true},
+		{"cat <(sort", 0, "1:11", true},
+		{"echo ${x:1}", 0, "1:6", false},
+		{"echo ${x:-a", 0, "1:6", true},
+		{"a=(x", 0, "1:5", true},
+		{"time |", 0, "1:6", false},
+	}
+
+	for _, tt := range tests {
+		list, err := parseLine(tt.input)
+		if tt.pos == "" {
+			if err != nil {
+				t.Errorf("parseLine(%q) unexpected error: %v", tt.input, err)
+			} else if len(list.Items) != tt.items {
+				t.Errorf("parseLine(%q) has %d items; want %d", tt.input, len(list.Items), tt.items)
+			}
+			continue
+		}
+		var se *SyntaxError
+		if !errors.As(err, &se) {
+			t.Errorf("parseLine(%q) error = %v; want a syntax error", tt.input, err)
+			continue
+		}
+		if se.Pos.String() != tt.pos || se.Incomplete != tt.incomplete {
+			t.Errorf("parseLine(%q) error at %s, incomplete %v; want %s, %v", tt.input, se.Pos, se.Incomplete, tt.pos, tt.incomplete)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"echo hi\nif true; then echo; fi", nil},
+		{"echo )\necho ok\nfi", []string{"1:6", "3:1"}},
+		{"if true; then\n\techo ${a b}\n\tfor 1 in; do :; done\n\techo ok\nfi", []string{"2:7", "3:6"}},
+		{"echo ${a b}; echo )\necho (", []string{"1:6", "2:7"}},
+		{"fi\nif true; then", []string{"1:1", "2:14"}},
+	}
+
+	for _, tt := range tests {
+		errs := Check(tt.input)
+		var got []string
+		for _, err := range errs {
+			got = append(got, err.Pos.String())
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("Check(%q) errors at %q; want %q", tt.input, got, tt.want)
+		}
+	}
+}
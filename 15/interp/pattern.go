@@ -0,0 +1,191 @@
+package interp
+
+import (
+	"os"
+	"sort"
+	"strings"
+)
+
+func quotePattern(s string) string {
+	out := strings.Builder{}
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			out.WriteByte('\\')
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
+
+// matchPattern reports whether s matches the shell pattern, which supports
+// *, ?, bracket expressions and backslash escapes.
+func matchPattern(pattern, s string) bool {
+	return matchRunes([]rune(pattern), []rune(s))
+}
+
+func matchRunes(p, s []rune) bool {
+	for len(p) > 0 {
+		switch p[0] {
+		case '*':
+			for len(p) > 0 && p[0] == '*' {
+				p = p[1:]
+			}
+			if len(p) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchRunes(p, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			p, s = p[1:], s[1:]
+			continue
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			if ok, n := matchClass(p, s[0]); n > 0 {
+				if !ok {
+					return false
+				}
+				p, s = p[n:], s[1:]
+				continue
+			}
+		case '\\':
+			if len(p) > 1 {
+				p = p[1:]
+			}
+		}
+		if len(s) == 0 || p[0] != s[0] {
+			return false
+		}
+		p, s = p[1:], s[1:]
+	}
+	return len(s) == 0
+}
+
+// matchClass matches c against the bracket expression at the start of p and
+// returns the expression's length, or 0 when p has no closing bracket.
+func matchClass(p []rune, c rune) (bool, int) {
+	i := 1
+	negate := false
+	if i < len(p) && (p[i] == '!' || p[i] == '^') {
+		negate = true
+		i++
+	}
+	matched := false
+	first := true
+	for i < len(p) {
+		if p[i] == ']' && !first {
+			return matched != negate, i + 1
+		}
+		first = false
+		lo := p[i]
+		if lo == '\\' && i+1 < len(p) {
+			i++
+			lo = p[i]
+		}
+		hi := lo
+		if i+2 < len(p) && p[i+1] == '-' && p[i+2] != ']' {
+			hi = p[i+2]
+			if hi == '\\' && i+3 < len(p) {
+				i++
+				hi = p[i+2]
+			}
+			i += 2
+		}
+		if lo <= c && c <= hi {
+			matched = true
+		}
+		i++
+	}
+	return false, 0
+}
+
+// glob returns the sorted paths matching pattern, matching each path
+// component separately so that wildcards never match a slash. Hidden files
+// are only matched by a component that starts with a literal dot. Relative
+// patterns are matched in cwd and give relative paths.
+func glob(pattern, cwd string) []string {
+	dir := ""
+	if strings.HasPrefix(pattern, "/") {
+		dir = "/"
+		pattern = strings.TrimLeft(pattern, "/")
+	}
+	matches := globDir(cwd, dir, strings.Split(pattern, "/"))
+	sort.Strings(matches)
+	return matches
+}
+
+func globDir(cwd, dir string, comps []string) []string {
+	if len(comps) == 0 {
+		return []string{dir}
+	}
+	comp, rest := comps[0], comps[1:]
+	if comp == "" {
+		if len(rest) == 0 {
+			if dir == "" {
+				return nil
+			}
+			return []string{strings.TrimSuffix(dir, "/") + "/"}
+		}
+		return globDir(cwd, dir, rest)
+	}
+	if !hasPatternMeta(comp) {
+		p := joinGlob(dir, unescape(comp, ""))
+		if _, err := os.Lstat(joinDir(cwd, p)); err != nil {
+			return nil
+		}
+		return globDir(cwd, p, rest)
+	}
+	entries, err := os.ReadDir(joinDir(cwd, dir))
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(comp, ".") && !strings.HasPrefix(comp, `\.`) {
+			continue
+		}
+		if !matchPattern(comp, name) {
+			continue
+		}
+		p := joinGlob(dir, name)
+		if len(rest) > 0 {
+			if fi, err := os.Stat(joinDir(cwd, p)); err != nil || !fi.IsDir() {
+				continue
+			}
+		}
+		out = append(out, globDir(cwd, p, rest)...)
+	}
+	return out
+}
+
+func joinGlob(dir, name string) string {
+	switch dir {
+	case "":
+		return name
+	case "/":
+		return "/" + name
+	}
+	return dir + "/" + name
+}
+
+// hasPatternMeta reports whether pattern contains an unescaped wildcard.
+func hasPatternMeta(pattern string) bool {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '*', '?', '[':
+			return true
+		}
+	}
+	return false
+}
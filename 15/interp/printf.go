@@ -0,0 +1,276 @@
+package interp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// builtinPrintf writes its arguments under the control of a format, as
+// printf(1) does. The format is reused until the arguments run out; a
+// missing argument reads as an empty string or zero.
+func builtinPrintf(cmd *Cmd) int {
+	args := cmd.Args[1:]
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		fmt.Fprintln(cmd.Stderr, "printf: usage: printf format [arguments]")
+		return 2
+	}
+	format, args := args[0], args[1:]
+	f := &printfState{args: args}
+	out := strings.Builder{}
+	for {
+		consumed := f.next
+		if f.format(&out, format) {
+			break
+		}
+		if f.next >= len(f.args) || f.next == consumed {
+			break
+		}
+	}
+	fmt.Fprint(cmd.Stdout, out.String())
+	for _, err := range f.errs {
+		fmt.Fprintln(cmd.Stderr, "printf:", err)
+	}
+	if len(f.errs) > 0 {
+		return 1
+	}
+	return 0
+}
+
+type printfState struct {
+	args []string
+	next int
+	errs []string
+}
+
+func (f *printfState) arg() string {
+	if f.next >= len(f.args) {
+		return ""
+	}
+	f.next++
+	return f.args[f.next-1]
+}
+
+// format writes one pass of format to out and reports whether a \c, in
+// the format or in a %b argument, ended the output.
+func (f *printfState) format(out *strings.Builder, format string) bool {
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c == '\\' {
+			s, n, stop := decodeEscape(format[i+1:], false)
+			if stop {
+				return true
+			}
+			out.WriteString(s)
+			i += n
+			continue
+		}
+		if c != '%' {
+			out.WriteByte(c)
+			continue
+		}
+		if i+1 < len(format) && format[i+1] == '%' {
+			out.WriteByte('%')
+			i++
+			continue
+		}
+
+		// %[flags][width][.precision]verb, where width and precision may be
+		// * to take them from the arguments.
+		j := i + 1
+		for j < len(format) && strings.IndexByte("-+ #0", format[j]) >= 0 {
+			j++
+		}
+		spec := format[i:j]
+		for _, part := range []string{"width", "precision"} {
+			if part == "precision" {
+				if j >= len(format) || format[j] != '.' {
+					break
+				}
+				spec += "."
+				j++
+			}
+			if j < len(format) && format[j] == '*' {
+				spec += strconv.Itoa(int(f.integer(f.arg())))
+				j++
+				continue
+			}
+			k := j
+			for j < len(format) && format[j] >= '0' && format[j] <= '9' {
+				j++
+			}
+			spec += format[k:j]
+		}
+		if j >= len(format) {
+			f.errs = append(f.errs, "`"+format[i:]+"': missing format character")
+			out.WriteString(format[i:])
+			return true
+		}
+		verb := format[j]
+		i = j
+		switch verb {
+		case 's':
+			fmt.Fprintf(out, spec+"s", f.arg())
+		case 'q':
+			fmt.Fprintf(out, spec+"s", shellQuote(f.arg()))
+		case 'b':
+			s, stop := decodeEscapes(f.arg())
+			fmt.Fprintf(out, spec+"s", s)
+			if stop {
+				return true
+			}
+		case 'c':
+			s := f.arg()
+			if s != "" {
+				_, n := utf8.DecodeRuneInString(s)
+				s = s[:n]
+			}
+			fmt.Fprintf(out, spec+"s", s)
+		case 'd', 'i':
+			fmt.Fprintf(out, spec+"d", f.integer(f.arg()))
+		case 'o', 'u', 'x', 'X':
+			goVerb := string(verb)
+			if verb == 'u' {
+				goVerb = "d"
+			}
+			fmt.Fprintf(out, spec+goVerb, uint64(f.integer(f.arg())))
+		case 'e', 'E', 'f', 'F', 'g', 'G':
+			goVerb := string(verb)
+			if verb == 'F' {
+				goVerb = "f"
+			}
+			fmt.Fprintf(out, spec+goVerb, f.float(f.arg()))
+		default:
+			f.errs = append(f.errs, "%"+string(verb)+": invalid format character")
+			return true
+		}
+	}
+	return false
+}
+
+// integer converts a numeric argument: decimal, 0x hex, 0 octal, or 'c
+// for the code of the character c.
+func (f *printfState) integer(s string) int64 {
+	if s == "" {
+		return 0
+	}
+	if s[0] == '\'' || s[0] == '"' {
+		r, _ := utf8.DecodeRuneInString(s[1:])
+		return int64(r)
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 0, 64)
+	if err != nil {
+		if u, uerr := strconv.ParseUint(strings.TrimSpace(s), 0, 64); uerr == nil {
+			return int64(u)
+		}
+		f.errs = append(f.errs, s+": invalid number")
+	}
+	return n
+}
+
+func (f *printfState) float(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	if s[0] == '\'' || s[0] == '"' {
+		r, _ := utf8.DecodeRuneInString(s[1:])
+		return float64(r)
+	}
+	x, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		f.errs = append(f.errs, s+": invalid number")
+	}
+	return x
+}
+
+// decodeEscapes expands the backslash escapes in s as %b does, and reports
+// whether a \c cut it short.
+func decodeEscapes(s string) (string, bool) {
+	out := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			out.WriteByte(s[i])
+			continue
+		}
+		e, n, stop := decodeEscape(s[i+1:], true)
+		if stop {
+			return out.String(), true
+		}
+		out.WriteString(e)
+		i += n
+	}
+	return out.String(), false
+}
+
+// decodeEscape decodes the escape sequence at the start of s, which follows
+// a backslash, returning its value and the number of bytes of s it used.
+// Octal escapes are \NNN, and also \0NNN when zeroLead is set, as in %b.
+// stop reports a \c.
+func decodeEscape(s string, zeroLead bool) (value string, n int, stop bool) {
+	if s == "" {
+		return "\\", 0, false
+	}
+	switch s[0] {
+	case 'a':
+		return "\a", 1, false
+	case 'b':
+		return "\b", 1, false
+	case 'c':
+		return "", 1, true
+	case 'e', 'E':
+		return "\033", 1, false
+	case 'f':
+		return "\f", 1, false
+	case 'n':
+		return "\n", 1, false
+	case 'r':
+		return "\r", 1, false
+	case 't':
+		return "\t", 1, false
+	case 'v':
+		return "\v", 1, false
+	case '\\':
+		return "\\", 1, false
+	case '"', '\'':
+		return s[:1], 1, false
+	case 'x':
+		v, k := 0, 1
+		for ; k < len(s) && k < 3 && isHexDigit(s[k]); k++ {
+			v = v*16 + hexValue(s[k])
+		}
+		if k == 1 {
+			return "\\x", 1, false
+		}
+		return string([]byte{byte(v)}), k, false
+	}
+	if s[0] >= '0' && s[0] <= '7' {
+		start := 0
+		if zeroLead && s[0] == '0' {
+			start = 1
+		}
+		v, k := 0, start
+		for ; k < len(s) && k < start+3 && s[k] >= '0' && s[k] <= '7'; k++ {
+			v = v*8 + int(s[k]-'0')
+		}
+		return string([]byte{byte(v)}), k, false
+	}
+	return "\\" + s[:1], 1, false
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func hexValue(c byte) int {
+	switch {
+	case c >= 'a':
+		return int(c-'a') + 10
+	case c >= 'A':
+		return int(c-'A') + 10
+	}
+	return int(c - '0')
+}
@@ -0,0 +1,206 @@
+package interp
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// prompt returns the primary prompt, or the continuation prompt when more
+// is true, built from PS1 or PS2. Backslash escapes are decoded first and
+// the result is then expanded like a double-quoted string.
+func (r *Runner) prompt(more bool) string {
+	if more {
+		ps2, ok := r.getVar("PS2")
+		if !ok {
+			return "> "
+		}
+		return r.expandPrompt(ps2)
+	}
+	ps1, ok := r.getVar("PS1")
+	if !ok {
+		return r.promptDir() + "$ "
+	}
+	return r.expandPrompt(ps1)
+}
+
+func (r *Runner) expandPrompt(ps string) string {
+	s := r.decodePrompt(ps)
+	if strings.ContainsAny(s, "$`") {
+		if v, err := r.expandString(s); err == nil {
+			s = v
+		}
+	}
+	return s
+}
+
+// expandString expands s as if it were written between double quotes.
+func (r *Runner) expandString(s string) (v string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			se, ok := r.(*SyntaxError)
+			if !ok {
+				panic(r)
+			}
+			err = se
+		}
+	}()
+	l := newLexer(s)
+	w := &Word{Parts: []WordPart{&DblQuoted{Parts: l.dblParts(0, l.pos())}}}
+	return r.expandWord(w)
+}
+
+// decodePrompt replaces the prompt escapes in ps:
+//
+//	\u user name        \h host name up to the first dot, \H all of it
+//	\w working dir      \W its last element; both abbreviate $HOME to ~
+//	\? exit status      \$ # for root, $ otherwise
+//	\t time, 24h        \T 12h, \@ 12h am/pm, \A hours and minutes, \d date
+//	\g git branch       \n newline, \e escape, \a bell, \\ backslash
+//	\nnn octal byte     \[ and \] bracket non-printing text and are dropped
+func (r *Runner) decodePrompt(ps string) string {
+	out := strings.Builder{}
+	for i := 0; i < len(ps); i++ {
+		c := ps[i]
+		if c != '\\' || i+1 == len(ps) {
+			out.WriteByte(c)
+			continue
+		}
+		i++
+		now := time.Now()
+		switch e := ps[i]; e {
+		case 'u':
+			out.WriteString(r.userName())
+		case 'h', 'H':
+			host, _ := os.Hostname()
+			if e == 'h' {
+				host, _, _ = strings.Cut(host, ".")
+			}
+			out.WriteString(host)
+		case 'w':
+			out.WriteString(r.tildeDir(r.promptDir()))
+		case 'W':
+			dir := r.tildeDir(r.promptDir())
+			if dir != "~" && dir != "/" {
+				dir = filepath.Base(dir)
+			}
+			out.WriteString(dir)
+		case '?':
+			out.WriteString(strconv.Itoa(r.lastStatus))
+		case '$':
+			if os.Geteuid() == 0 {
+				out.WriteByte('#')
+			} else {
+				out.WriteByte('$')
+			}
+		case 't':
+			out.WriteString(now.Format("15:04:05"))
+		case 'T':
+			out.WriteString(now.Format("03:04:05"))
+		case '@':
+			out.WriteString(now.Format("03:04 PM"))
+		case 'A':
+			out.WriteString(now.Format("15:04"))
+		case 'd':
+			out.WriteString(now.Format("Mon Jan 02"))
+		case 'g':
+			out.WriteString(gitBranch(r.promptDir()))
+		case 'n':
+			out.WriteByte('\n')
+		case 'e':
+			out.WriteByte('\033')
+		case 'a':
+			out.WriteByte('\a')
+		case '\\':
+			out.WriteByte('\\')
+		case '[', ']':
+		case '0', '1', '2', '3':
+			n, j := 0, i
+			for ; j < len(ps) && j < i+3 && ps[j] >= '0' && ps[j] <= '7'; j++ {
+				n = n*8 + int(ps[j]-'0')
+			}
+			out.WriteByte(byte(n))
+			i = j - 1
+		default:
+			out.WriteByte('\\')
+			out.WriteByte(e)
+		}
+	}
+	return out.String()
+}
+
+func (r *Runner) userName() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	name, _ := r.getVar("USER")
+	return name
+}
+
+// promptDir is $PWD, which keeps the symbolic links cd followed.
+func (r *Runner) promptDir() string {
+	if pwd, ok := r.getVar("PWD"); ok && pwd != "" {
+		return pwd
+	}
+	return r.dir
+}
+
+// tildeDir abbreviates a leading $HOME in dir to ~.
+func (r *Runner) tildeDir(dir string) string {
+	home, _ := r.getVar("HOME")
+	if home == "" || home == "/" {
+		return dir
+	}
+	if dir == home {
+		return "~"
+	}
+	if rest, ok := strings.CutPrefix(dir, home+"/"); ok {
+		return "~/" + rest
+	}
+	return dir
+}
+
+// gitBranch returns the branch checked out in the git repository holding
+// dir, or a short commit hash for a detached HEAD. It reads .git/HEAD
+// directly, following the gitdir: link of worktrees and submodules.
+func gitBranch(dir string) string {
+	for {
+		gitDir := filepath.Join(dir, ".git")
+		if fi, err := os.Stat(gitDir); err == nil {
+			if !fi.IsDir() {
+				data, err := os.ReadFile(gitDir)
+				if err != nil {
+					return ""
+				}
+				link, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+				if !ok {
+					return ""
+				}
+				if !filepath.IsAbs(link) {
+					link = filepath.Join(dir, link)
+				}
+				gitDir = link
+			}
+			data, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+			if err != nil {
+				return ""
+			}
+			head := strings.TrimSpace(string(data))
+			if ref, ok := strings.CutPrefix(head, "ref: "); ok {
+				return strings.TrimPrefix(ref, "refs/heads/")
+			}
+			if len(head) > 7 {
+				head = head[:7]
+			}
+			return head
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
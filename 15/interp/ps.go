@@ -0,0 +1,300 @@
+package interp
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// clockTicks is the unit of the times in /proc/<pid>/stat, USER_HZ, which
+// is 100 on every Linux architecture.
+const clockTicks = 100
+
+// procInfo is what ps knows about a process, read from /proc/<pid>/stat,
+// /proc/<pid>/cmdline and the owner of /proc/<pid>.
+type procInfo struct {
+	pid, ppid, pgid, sid int
+	state                string
+	tty                  int
+	ticks                int64
+	nice, threads        int64
+	vsz, rss             int64 // in KiB
+	uid                  uint32
+	comm, args           string
+}
+
+// psColumn is a column ps can print and sort on. A column with a num
+// function is right-aligned and sorts numerically.
+type psColumn struct {
+	header string
+	text   func(p *procInfo) string
+	num    func(p *procInfo) int64
+}
+
+var psColumns = map[string]psColumn{
+	"pid":     numColumn("PID", func(p *procInfo) int64 { return int64(p.pid) }),
+	"ppid":    numColumn("PPID", func(p *procInfo) int64 { return int64(p.ppid) }),
+	"pgid":    numColumn("PGID", func(p *procInfo) int64 { return int64(p.pgid) }),
+	"sid":     numColumn("SID", func(p *procInfo) int64 { return int64(p.sid) }),
+	"nice":    numColumn("NI", func(p *procInfo) int64 { return p.nice }),
+	"nlwp":    numColumn("NLWP", func(p *procInfo) int64 { return p.threads }),
+	"vsz":     numColumn("VSZ", func(p *procInfo) int64 { return p.vsz }),
+	"rss":     numColumn("RSS", func(p *procInfo) int64 { return p.rss }),
+	"uid":     numColumn("UID", func(p *procInfo) int64 { return int64(p.uid) }),
+	"user":    {header: "USER", text: func(p *procInfo) string { return lookupUser(p.uid) }},
+	"stat":    {header: "STAT", text: func(p *procInfo) string { return p.state }},
+	"tty":     {header: "TT", text: func(p *procInfo) string { return ttyName(p.tty) }},
+	"comm":    {header: "COMMAND", text: func(p *procInfo) string { return p.comm }},
+	"args":    {header: "COMMAND", text: func(p *procInfo) string { return p.args }},
+	"time":    {header: "TIME", text: func(p *procInfo) string { return cpuTime(p.ticks) }, num: func(p *procInfo) int64 { return p.ticks }},
+	"cputime": {header: "TIME", text: func(p *procInfo) string { return cpuTime(p.ticks) }, num: func(p *procInfo) int64 { return p.ticks }},
+}
+
+func numColumn(header string, num func(p *procInfo) int64) psColumn {
+	return psColumn{
+		header: header,
+		text:   func(p *procInfo) string { return strconv.FormatInt(num(p), 10) },
+		num:    num,
+	}
+}
+
+const psDefaultColumns = "user,pid,ppid,stat,tty,rss,time,args"
+
+// builtinPs lists every process. -o picks the columns from psColumns,
+// -p restricts the list to the given pids and --sort (or -k) orders it by
+// one or more columns, each descending when prefixed with '-'.
+//
+//	ps [-o col,...] [-p pid,...] [--sort [+|-]col,...]
+func builtinPs(cmd *Cmd) int {
+	cols, sortKeys := psDefaultColumns, "pid"
+	var pids map[int]bool
+	args := cmd.Args[1:]
+	for len(args) > 0 {
+		opt := args[0]
+		if opt != "-o" && opt != "-p" && opt != "-k" && opt != "--sort" {
+			fmt.Fprintf(cmd.Stderr, "ps: %s: unknown option\n", opt)
+			fmt.Fprintln(cmd.Stderr, "ps: usage: ps [-o col,...] [-p pid,...] [--sort [+|-]col,...]")
+			return 2
+		}
+		if len(args) < 2 {
+			fmt.Fprintf(cmd.Stderr, "ps: %s: option requires an argument\n", opt)
+			return 2
+		}
+		switch opt {
+		case "-o":
+			cols = args[1]
+		case "-k", "--sort":
+			sortKeys = args[1]
+		case "-p":
+			pids = map[int]bool{}
+			for _, s := range strings.Split(args[1], ",") {
+				pid, err := strconv.Atoi(s)
+				if err != nil {
+					fmt.Fprintf(cmd.Stderr, "ps: %s: invalid process id\n", s)
+					return 2
+				}
+				pids[pid] = true
+			}
+		}
+		args = args[2:]
+	}
+
+	var columns []psColumn
+	for _, name := range strings.Split(cols, ",") {
+		c, ok := psColumns[name]
+		if !ok {
+			fmt.Fprintf(cmd.Stderr, "ps: %s: unknown column\n", name)
+			return 2
+		}
+		columns = append(columns, c)
+	}
+	type sortKey struct {
+		col  psColumn
+		desc bool
+	}
+	var keys []sortKey
+	for _, name := range strings.Split(sortKeys, ",") {
+		desc := strings.HasPrefix(name, "-")
+		name = strings.TrimLeft(name, "+-")
+		c, ok := psColumns[name]
+		if !ok {
+			fmt.Fprintf(cmd.Stderr, "ps: %s: unknown sort key\n", name)
+			return 2
+		}
+		keys = append(keys, sortKey{c, desc})
+	}
+
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		fmt.Fprintln(cmd.Stderr, "ps:", err)
+		return 1
+	}
+	var procs []*procInfo
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil || pids != nil && !pids[pid] {
+			continue
+		}
+		// The process may exit while it is being read.
+		if p, err := readProc(pid); err == nil {
+			procs = append(procs, p)
+		}
+	}
+	sort.SliceStable(procs, func(i, j int) bool {
+		for _, k := range keys {
+			a, b := procs[i], procs[j]
+			if k.desc {
+				a, b = b, a
+			}
+			if k.col.num != nil {
+				if x, y := k.col.num(a), k.col.num(b); x != y {
+					return x < y
+				}
+				continue
+			}
+			if x, y := k.col.text(a), k.col.text(b); x != y {
+				return x < y
+			}
+		}
+		return false
+	})
+
+	rows := [][]string{make([]string, len(columns))}
+	widths := make([]int, len(columns))
+	for i, c := range columns {
+		rows[0][i] = c.header
+	}
+	for _, p := range procs {
+		row := make([]string, len(columns))
+		for i, c := range columns {
+			row[i] = c.text(p)
+		}
+		rows = append(rows, row)
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], len(cell))
+		}
+	}
+	for _, row := range rows {
+		line := strings.Builder{}
+		for i, cell := range row {
+			if i > 0 {
+				line.WriteByte(' ')
+			}
+			switch {
+			case columns[i].num != nil:
+				fmt.Fprintf(&line, "%*s", widths[i], cell)
+			case i == len(row)-1:
+				line.WriteString(cell)
+			default:
+				fmt.Fprintf(&line, "%-*s", widths[i], cell)
+			}
+		}
+		fmt.Fprintln(cmd.Stdout, line.String())
+	}
+	if pids != nil && len(procs) == 0 {
+		return 1
+	}
+	return 0
+}
+
+func readProc(pid int) (*procInfo, error) {
+	dir := filepath.Join("/proc", strconv.Itoa(pid))
+	data, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	// The command name is in parentheses and may itself hold spaces and
+	// parentheses, so the fields after it are found from the last ')'.
+	stat := string(data)
+	open, close := strings.IndexByte(stat, '('), strings.LastIndexByte(stat, ')')
+	if open < 0 || close < open {
+		return nil, fmt.Errorf("%s/stat: malformed", dir)
+	}
+	f := strings.Fields(stat[close+1:])
+	if len(f) < 22 {
+		return nil, fmt.Errorf("%s/stat: malformed", dir)
+	}
+	num := func(i int) int64 {
+		n, _ := strconv.ParseInt(f[i], 10, 64)
+		return n
+	}
+	p := &procInfo{
+		pid:     pid,
+		comm:    stat[open+1 : close],
+		state:   f[0],
+		ppid:    int(num(1)),
+		pgid:    int(num(2)),
+		sid:     int(num(3)),
+		tty:     int(num(4)),
+		ticks:   num(11) + num(12),
+		nice:    num(16),
+		threads: num(17),
+		vsz:     num(20) / 1024,
+		rss:     num(21) * int64(os.Getpagesize()) / 1024,
+	}
+	if fi, err := os.Stat(dir); err == nil {
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+			p.uid = st.Uid
+		}
+	}
+	cmdline, _ := os.ReadFile(filepath.Join(dir, "cmdline"))
+	p.args = strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " "))
+	if p.args == "" {
+		// Kernel threads and zombies have no command line.
+		p.args = "[" + p.comm + "]"
+	}
+	return p, nil
+}
+
+var (
+	userMu    sync.Mutex
+	userNames = map[uint32]string{}
+)
+
+func lookupUser(uid uint32) string {
+	userMu.Lock()
+	defer userMu.Unlock()
+	if name, ok := userNames[uid]; ok {
+		return name
+	}
+	name := strconv.FormatUint(uint64(uid), 10)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+	userNames[uid] = name
+	return name
+}
+
+// ttyName decodes the tty_nr field of /proc/<pid>/stat.
+func ttyName(nr int) string {
+	major, minor := nr>>8&0xfff, nr&0xff|nr>>12&0xfff00
+	switch {
+	case nr == 0:
+		return "?"
+	case major >= 136 && major <= 143:
+		return "pts/" + strconv.Itoa((major-136)<<8+minor)
+	case major == 4 && minor < 64:
+		return "tty" + strconv.Itoa(minor)
+	case major == 4:
+		return "ttyS" + strconv.Itoa(minor-64)
+	}
+	return strconv.Itoa(major) + "," + strconv.Itoa(minor)
+}
+
+// cpuTime formats clock ticks as [DD-]HH:MM:SS.
+func cpuTime(ticks int64) string {
+	s := ticks / clockTicks
+	t := fmt.Sprintf("%02d:%02d:%02d", s/3600%24, s/60%60, s%60)
+	if days := s / 86400; days > 0 {
+		t = fmt.Sprintf("%d-%s", days, t)
+	}
+	return t
+}
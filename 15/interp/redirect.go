@@ -0,0 +1,222 @@
+package interp
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Redir is an expanded redirection applied to descriptor Fd. Target is a
+// file name, a descriptor number or "-" for the duplicating operators <& and
+// >&, and the document text for here-documents and here-strings.
+type Redir struct {
+	Fd     int
+	Op     string
+	Target string
+}
+
+// docPipeMax is the largest here-document that is fed through a pipe; the
+// whole body is written before the command starts, so it has to fit in the
+// pipe buffer. Longer documents go through an unlinked temporary file.
+const docPipeMax = 32 << 10
+
+func (r *Runner) expandRedir(redir *Redirect) (Redir, error) {
+	rd := Redir{Fd: redir.Fd, Op: redir.Op}
+	if rd.Fd < 0 {
+		rd.Fd = 1
+		if redir.Op[0] == '<' {
+			rd.Fd = 0
+		}
+	}
+	var err error
+	switch redir.Op {
+	case "<<", "<<-":
+		rd.Target, err = r.expandWord(redir.Doc)
+	case "<<<":
+		rd.Target, err = r.expandWord(redir.Target)
+		rd.Target += "\n"
+	default:
+		rd.Target, err = r.expandWord(redir.Target)
+	}
+	if err != nil {
+		return rd, err
+	}
+	if redir.Op == ">&" && redir.Fd < 0 && rd.Target != "-" {
+		if _, err := strconv.Atoi(rd.Target); err != nil {
+			rd.Op = "&>"
+		}
+	}
+	return rd, nil
+}
+
+// applyRedirs performs redirs in order on the descriptor table fds, indexed
+// by descriptor number, where a nil entry is a closed descriptor. It returns
+// the new table and the files it opened, which the caller closes once the
+// command has started.
+func (r *Runner) applyRedirs(fds []*os.File, redirs []Redir) ([]*os.File, []*os.File, error) {
+	fds = append([]*os.File(nil), fds...)
+	var opened []*os.File
+	set := func(fd int, f *os.File) {
+		for len(fds) <= fd {
+			fds = append(fds, nil)
+		}
+		fds[fd] = f
+	}
+	for _, rd := range redirs {
+		var f *os.File
+		var err error
+		switch rd.Op {
+		case "<&", ">&":
+			if rd.Target == "-" {
+				set(rd.Fd, nil)
+				continue
+			}
+			n, err := strconv.Atoi(rd.Target)
+			if err != nil || n < 0 || n >= len(fds) || fds[n] == nil {
+				return fds, opened, fmt.Errorf("%s: bad file descriptor", rd.Target)
+			}
+			set(rd.Fd, fds[n])
+			continue
+		case "<":
+			f, err = r.open(rd.Target, os.O_RDONLY, 0)
+		case ">", ">|", "&>":
+			f, err = r.open(rd.Target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+		case ">>", "&>>":
+			f, err = r.open(rd.Target, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+		case "<>":
+			f, err = r.open(rd.Target, os.O_RDWR|os.O_CREATE, 0666)
+		case "<<", "<<-", "<<<":
+			f, err = docFile(rd.Target)
+		default:
+			return fds, opened, fmt.Errorf("%s: unsupported redirection", rd.Op)
+		}
+		if err != nil {
+			var pe *os.PathError
+			if errors.As(err, &pe) {
+				err = fmt.Errorf("%s: %v", rd.Target, pe.Err)
+			}
+			return fds, opened, err
+		}
+		opened = append(opened, f)
+		if rd.Op == "&>" || rd.Op == "&>>" {
+			set(1, f)
+			set(2, f)
+			continue
+		}
+		set(rd.Fd, f)
+	}
+	return fds, opened, nil
+}
+
+// docFile returns a file positioned at the start of body, for use as the
+// standard input of a here-document.
+func docFile(body string) (*os.File, error) {
+	if len(body) <= docPipeMax {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		_, err = w.WriteString(body)
+		w.Close()
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		return r, nil
+	}
+	f, err := os.CreateTemp("", "gosh-doc")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(f.Name())
+	if _, err := f.WriteString(body); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// withRedirs runs fn with redirs applied to the shell's own
+// descriptors, so that everything fn runs sees them.
+func (r *Runner) withRedirs(redirs []Redir, fn func() int) int {
+	if len(redirs) == 0 {
+		return fn()
+	}
+	fds, opened, err := r.applyRedirs(r.shellFds(), redirs)
+	defer closeFiles(opened)
+	if err != nil {
+		fmt.Fprintln(r.stderr, err)
+		return 1
+	}
+	savedIn, savedOut, savedErr, savedExtra := r.stdin, r.stdout, r.stderr, r.extraFds
+	r.stdin, r.stdout, r.stderr, r.extraFds = fds[0], fds[1], fds[2], fds[3:]
+	defer func() {
+		r.stdin, r.stdout, r.stderr, r.extraFds = savedIn, savedOut, savedErr, savedExtra
+	}()
+	return fn()
+}
+
+// shellFds returns the shell's descriptor table, indexed by number.
+func (r *Runner) shellFds() []*os.File {
+	return append([]*os.File{r.stdin, r.stdout, r.stderr}, r.extraFds...)
+}
+
+// addShellFd puts f in the shell's descriptor table at the highest free
+// descriptor counting down from 63, as bash numbers the descriptors it
+// opens for itself, and returns the number.
+func (r *Runner) addShellFd(f *os.File) int {
+	fds := r.shellFds()
+	n := 63
+	for n > 10 && n < len(fds) && fds[n] != nil {
+		n--
+	}
+	extra := append([]*os.File(nil), r.extraFds...)
+	for len(extra) <= n-3 {
+		extra = append(extra, nil)
+	}
+	extra[n-3] = f
+	r.extraFds = extra
+	return n
+}
+
+// removeShellFd takes f out of the shell's descriptor table if it is still
+// descriptor n there.
+func (r *Runner) removeShellFd(n int, f *os.File) {
+	if n-3 < len(r.extraFds) && r.extraFds[n-3] == f {
+		extra := append([]*os.File(nil), r.extraFds...)
+		extra[n-3] = nil
+		r.extraFds = extra
+	}
+}
+
+// inheritedFds returns the descriptors from 3 up that an external command
+// gets, which leaves out those of coprocesses.
+func (r *Runner) inheritedFds(extra []*os.File) []*os.File {
+	var fds []*os.File
+	for i, f := range extra {
+		if _, ok := r.coprocFds[f]; ok {
+			if fds == nil {
+				fds = append([]*os.File(nil), extra...)
+			}
+			fds[i] = nil
+		}
+	}
+	if fds == nil {
+		return extra
+	}
+	return fds
+}
+
+func closeFiles(files []*os.File) {
+	for i, f := range files {
+		if f != nil {
+			f.Close()
+			files[i] = nil
+		}
+	}
+}
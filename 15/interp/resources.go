@@ -0,0 +1,317 @@
+package interp
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// timeJob runs pl, which is prefixed with time, and then reports on the
+// shell's standard error how long it took, the processor time used
+// meanwhile by the shell and the processes it waited for, and the largest
+// resident set size of those processes.
+func (r *Runner) timeJob(pl *Pipeline) int {
+	untimed := *pl
+	untimed.Time = false
+	var self0, children0, self1, children1 syscall.Rusage
+	_ = syscall.Getrusage(syscall.RUSAGE_SELF, &self0)
+	_ = syscall.Getrusage(syscall.RUSAGE_CHILDREN, &children0)
+	savedRSS := r.maxRSS
+	r.maxRSS = 0
+	start := time.Now()
+
+	status := r.runJob(&untimed, false)
+
+	real := time.Since(start)
+	_ = syscall.Getrusage(syscall.RUSAGE_SELF, &self1)
+	_ = syscall.Getrusage(syscall.RUSAGE_CHILDREN, &children1)
+	user := elapsed(self1.Utime, self0.Utime) + elapsed(children1.Utime, children0.Utime)
+	sys := elapsed(self1.Stime, self0.Stime) + elapsed(children1.Stime, children0.Stime)
+	rss := r.maxRSS
+	r.maxRSS = max(savedRSS, rss)
+	if pl.TimePosix {
+		fmt.Fprintf(r.stderr, "real %.2f\nuser %.2f\nsys %.2f\n", real.Seconds(), user.Seconds(), sys.Seconds())
+	} else {
+		fmt.Fprintf(r.stderr, "\nreal\t%s\nuser\t%s\nsys\t%s\nmaxrss\t%dk\n", minutes(real), minutes(user), minutes(sys), rss)
+	}
+	return status
+}
+
+// elapsed returns the time from the rusage reading start to end.
+func elapsed(end, start syscall.Timeval) time.Duration {
+	return time.Duration(end.Nano() - start.Nano())
+}
+
+// minutes formats d the way bash's time does, as in 0m1.250s.
+func minutes(d time.Duration) string {
+	return fmt.Sprintf("%dm%.3fs", int(d.Minutes()), (d % time.Minute).Seconds())
+}
+
+// builtinTimes prints the user and system time used by the shell, and then
+// by the commands it has waited for.
+func builtinTimes(cmd *Cmd) int {
+	var self, children syscall.Rusage
+	_ = syscall.Getrusage(syscall.RUSAGE_SELF, &self)
+	_ = syscall.Getrusage(syscall.RUSAGE_CHILDREN, &children)
+	zero := syscall.Timeval{}
+	fmt.Fprintf(cmd.Stdout, "%s %s\n", minutes(elapsed(self.Utime, zero)), minutes(elapsed(self.Stime, zero)))
+	fmt.Fprintf(cmd.Stdout, "%s %s\n", minutes(elapsed(children.Utime, zero)), minutes(elapsed(children.Stime, zero)))
+	return 0
+}
+
+// Resource numbers that the syscall package leaves out, as they are on the
+// common Linux architectures.
+const (
+	rlimitRSS     = 5
+	rlimitNproc   = 6
+	rlimitMemlock = 8
+)
+
+// rlimits are the resources ulimit manages, by option letter. Values are
+// given to ulimit in units of factor bytes.
+var rlimits = []struct {
+	letter   byte
+	resource int
+	name     string
+	unit     string
+	factor   uint64
+}{
+	{'c', syscall.RLIMIT_CORE, "core file size", "blocks", 1024},
+	{'d', syscall.RLIMIT_DATA, "data seg size", "kbytes", 1024},
+	{'f', syscall.RLIMIT_FSIZE, "file size", "blocks", 1024},
+	{'l', rlimitMemlock, "max locked memory", "kbytes", 1024},
+	{'m', rlimitRSS, "max memory size", "kbytes", 1024},
+	{'n', syscall.RLIMIT_NOFILE, "open files", "", 1},
+	{'s', syscall.RLIMIT_STACK, "stack size", "kbytes", 1024},
+	{'t', syscall.RLIMIT_CPU, "cpu time", "seconds", 1},
+	{'u', rlimitNproc, "max user processes", "", 1},
+	{'v', syscall.RLIMIT_AS, "virtual memory", "kbytes", 1024},
+}
+
+const rlimInfinity = ^uint64(0)
+
+// builtinUlimit prints or sets resource limits. The limits belong to the
+// shell and its subshells, not to the process running it: the commands the
+// shell starts are given them as they start. -H and -S pick the hard or soft
+// limit. As in zsh, only the soft limit is set unless -H is given, since a
+// lowered hard limit cannot be raised again. -a prints them all. Without a
+// resource option the file size limit is meant.
+func (r *Runner) builtinUlimit(cmd *Cmd) int {
+	args := cmd.Args[1:]
+	hard, soft, all := false, false, false
+	var which []int
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		opt := args[0]
+		args = args[1:]
+		if opt == "--" {
+			break
+		}
+	letters:
+		for i := 1; i < len(opt); i++ {
+			switch opt[i] {
+			case 'H':
+				hard = true
+				continue
+			case 'S':
+				soft = true
+				continue
+			case 'a':
+				all = true
+				continue
+			}
+			for k, rl := range rlimits {
+				if rl.letter == opt[i] {
+					which = append(which, k)
+					continue letters
+				}
+			}
+			fmt.Fprintf(cmd.Stderr, "ulimit: -%c: invalid option\n", opt[i])
+			fmt.Fprintln(cmd.Stderr, "ulimit: usage: ulimit [-SHacdflmnstuv] [limit]")
+			return 2
+		}
+	}
+	if len(args) > 1 {
+		fmt.Fprintln(cmd.Stderr, "ulimit: too many arguments")
+		return 2
+	}
+	if all {
+		which = which[:0]
+		for k := range rlimits {
+			which = append(which, k)
+		}
+	}
+	if len(which) == 0 {
+		which = []int{2}
+	}
+
+	if len(args) == 0 || all {
+		for _, k := range which {
+			rl := rlimits[k]
+			lim, err := r.getRlimit(rl.resource)
+			if err != nil {
+				fmt.Fprintf(cmd.Stderr, "ulimit: %s: cannot get limit: %v\n", rl.name, err)
+				return 1
+			}
+			v := lim.Cur
+			if hard && !soft {
+				v = lim.Max
+			}
+			value := "unlimited"
+			if v != rlimInfinity {
+				value = strconv.FormatUint(v/rl.factor, 10)
+			}
+			if len(which) == 1 {
+				fmt.Fprintln(cmd.Stdout, value)
+				continue
+			}
+			unit := fmt.Sprintf("(-%c)", rl.letter)
+			if rl.unit != "" {
+				unit = fmt.Sprintf("(%s, -%c)", rl.unit, rl.letter)
+			}
+			fmt.Fprintf(cmd.Stdout, "%-*s%s %s\n", 40-len(unit), rl.name, unit, value)
+		}
+		return 0
+	}
+
+	if !hard {
+		soft = true
+	}
+	status := 0
+	for _, k := range which {
+		rl := rlimits[k]
+		lim, err := r.getRlimit(rl.resource)
+		if err != nil {
+			fmt.Fprintf(cmd.Stderr, "ulimit: %s: cannot get limit: %v\n", rl.name, err)
+			status = 1
+			continue
+		}
+		var v uint64
+		switch args[0] {
+		case "unlimited":
+			v = rlimInfinity
+		case "hard":
+			v = lim.Max
+		case "soft":
+			v = lim.Cur
+		default:
+			n, err := strconv.ParseUint(args[0], 10, 64)
+			if err != nil || n > rlimInfinity/rl.factor {
+				fmt.Fprintf(cmd.Stderr, "ulimit: %s: invalid number\n", args[0])
+				return 1
+			}
+			v = n * rl.factor
+		}
+		old := lim
+		if hard {
+			lim.Max = v
+			lim.Cur = min(lim.Cur, v)
+		}
+		if soft {
+			lim.Cur = v
+		}
+		// Check what setrlimit would, as the limits are only applied when
+		// a command starts.
+		switch {
+		case lim.Cur > lim.Max:
+			err = syscall.EINVAL
+		case lim.Max > old.Max && os.Geteuid() != 0:
+			err = syscall.EPERM
+		}
+		if err != nil {
+			fmt.Fprintf(cmd.Stderr, "ulimit: %s: cannot modify limit: %v\n", rl.name, err)
+			status = 1
+			continue
+		}
+		limits := maps.Clone(r.limits)
+		if limits == nil {
+			limits = map[int]syscall.Rlimit{}
+		}
+		limits[rl.resource] = lim
+		r.limits = limits
+	}
+	return status
+}
+
+// getRlimit returns the limit on resource that the commands the shell
+// starts get.
+func (r *Runner) getRlimit(resource int) (syscall.Rlimit, error) {
+	if lim, ok := r.limits[resource]; ok {
+		return lim, nil
+	}
+	var lim syscall.Rlimit
+	err := syscall.Getrlimit(resource, &lim)
+	return lim, err
+}
+
+// startCmd starts cmd with the limits set with ulimit and returns the proc
+// for it. So that the command runs none of its own code before it has
+// them, it is traced up to its exec and given them while stopped there. A
+// set-user-ID or set-group-ID program would not get its privileges if it
+// were traced, and gets the limits just after it starts instead.
+func (r *Runner) startCmd(cmd *exec.Cmd) (*proc, error) {
+	if len(r.limits) == 0 {
+		if err := cmd.Start(); err != nil {
+			return nil, err
+		}
+		return &proc{pid: cmd.Process.Pid}, nil
+	}
+	fi, err := os.Stat(cmd.Path)
+	traced := err == nil && fi.Mode()&(os.ModeSetuid|os.ModeSetgid) == 0
+	if traced {
+		// The thread that starts a traced process is its tracer.
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		cmd.SysProcAttr.Ptrace = true
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	p := &proc{pid: cmd.Process.Pid}
+	var sig syscall.Signal
+	if traced {
+		var ws syscall.WaitStatus
+		for {
+			_, err = syscall.Wait4(p.pid, &ws, 0, nil)
+			if err != syscall.EINTR {
+				break
+			}
+		}
+		if err != nil || !ws.Stopped() {
+			// It was killed before it stopped.
+			p.done, p.status = true, ws
+			return p, nil
+		}
+		// The stop is for the SIGTRAP sent on exec, or for a signal
+		// that came first and is passed on.
+		if sig = ws.StopSignal(); sig == syscall.SIGTRAP {
+			sig = 0
+		}
+	}
+	err = r.applyRlimits(p.pid)
+	if traced {
+		_, _, _ = syscall.RawSyscall6(syscall.SYS_PTRACE, syscall.PTRACE_DETACH, uintptr(p.pid), 0, uintptr(sig), 0, 0)
+	}
+	if err != nil {
+		_ = syscall.Kill(p.pid, syscall.SIGKILL)
+		_, _ = syscall.Wait4(p.pid, nil, 0, nil)
+		return nil, fmt.Errorf("%s: %w", cmd.Path, fmt.Errorf("cannot set resource limits: %w", err))
+	}
+	return p, nil
+}
+
+// applyRlimits gives the process pid the limits set with ulimit.
+func (r *Runner) applyRlimits(pid int) error {
+	for resource, lim := range r.limits {
+		_, _, e := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource), uintptr(unsafe.Pointer(&lim)), 0, 0, 0)
+		if e != 0 {
+			return e
+		}
+	}
+	return nil
+}
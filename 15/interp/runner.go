@@ -0,0 +1,388 @@
+// Package interp parses and runs shell code. A Runner holds the state of
+// one shell: its variables, functions, aliases, options, traps, jobs and
+// working directory.
+package interp
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Runner runs shell code. The exported fields configure it and are read
+// when it first runs; changing them afterwards has no effect.
+type Runner struct {
+	// Stdin, Stdout and Stderr are the shell's standard streams. An
+	// *os.File is given to commands as it is; any other reader or writer
+	// is connected to them through a pipe. Nil means the null device.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Env is the environment the shell starts with, in NAME=value form.
+	// Nil means the environment of the current process.
+	Env []string
+
+	// Dir is the working directory the shell starts in. Empty means the
+	// working directory of the current process. The shell keeps its own
+	// working directory, so cd never changes the process's.
+	Dir string
+
+	// Name and Params are $0 and the positional parameters.
+	Name   string
+	Params []string
+
+	// ExecHandler, if set, runs external commands in place of starting a
+	// process. It gets the command with its full environment, working
+	// directory and open streams, and returns the exit status.
+	ExecHandler func(cmd *Cmd) int
+
+	// OpenHandler, if set, opens the files named in redirections, in place
+	// of os.OpenFile. Relative paths have already been made absolute.
+	OpenHandler func(path string, flag int, perm os.FileMode) (*os.File, error)
+
+	started bool
+
+	// stdin, stdout and stderr are the shell's standard descriptors as seen
+	// by builtins and by the ends of a pipeline. Redirections on a builtin
+	// swap them for the duration of the command; command substitution
+	// points stdout at a pipe.
+	stdin, stdout, stderr *os.File
+	// extraFds are descriptors 3 and up, set by redirections on a compound
+	// command for the commands inside it, and by coproc.
+	extraFds []*os.File
+	// procSubsts are the pipe ends that process substitutions put in the
+	// descriptor table, which stay open until their command is done.
+	procSubsts []shellFd
+	// coprocFds are the shell's ends of coprocess pipes, mapped to false
+	// for those a subshell got among ownFds rather than opened.
+	coprocFds map[*os.File]bool
+	// ownFds are the descriptors a subshell was given, which it closes
+	// when it ends.
+	ownFds []*os.File
+
+	// dir is the working directory, always absolute and clean.
+	dir string
+
+	vars       map[string]*variable
+	lastStatus int
+	lastBgPid  int
+	scriptName string
+	positional []string
+	// substStatus is the exit status of the last command substitution,
+	// which becomes $? for a command made only of assignments.
+	substStatus int
+
+	functions map[string]*FuncDecl
+	aliases   map[string]string
+	// localScopes holds, for each function call in progress, the values
+	// that its local variables shadow; nil stands for an unset variable.
+	localScopes []map[string]*variable
+	// returning is set by return until the function call unwinds.
+	returning bool
+	// sourceDepth counts the files being sourced, where return is allowed.
+	sourceDepth int
+
+	loopDepth int
+	breakN    int
+	continueN int
+
+	// exiting is set by exit until the shell, or the subshell running it,
+	// unwinds; exitCode is the status it asked for.
+	exiting  bool
+	exitCode int
+
+	// dirStack holds the directories pushd saved, most recent first. The
+	// current directory is the implicit top of the stack and is not stored.
+	dirStack []string
+
+	optPipefail bool
+	optErrexit  bool
+	optNounset  bool
+	optXtrace   bool
+	// errexitIgnored counts the contexts being run where set -e does not
+	// apply: conditions, and-or chains before their last command and
+	// pipelines negated with !.
+	errexitIgnored int
+	// substDepth counts the command substitutions in progress; set -x
+	// repeats the first character of PS4 once more for each.
+	substDepth int
+
+	// maxRSS is the largest resident set size, in kilobytes, of the
+	// foreground processes waited for since time last reset it.
+	maxRSS int64
+	// audit is where a line describing each pipeline is written, if set.
+	audit *auditLog
+
+	// limits are the resource limits set with ulimit, by resource, which
+	// the commands the shell starts get in place of the process's own. The
+	// map is replaced rather than changed, so subshells share it.
+	limits map[int]syscall.Rlimit
+
+	// sigc receives the signals the process catches for the shell. It is
+	// nil until CatchSignals or EnableJobControl, and until then trap only
+	// changes the shell's own state.
+	sigc    chan os.Signal
+	sigMu   sync.Mutex
+	pending []syscall.Signal
+	// traps maps a signal, or 0 for EXIT, to the commands trap set for it.
+	// An empty action ignores the signal.
+	traps map[syscall.Signal]string
+	// inTrap is set while a trap action runs, so that it is not re-entered.
+	inTrap bool
+	// interruptReq is set by an untrapped SIGINT and stops the commands
+	// being run, like break does for a loop.
+	interruptReq bool
+	// async is set for a subshell running alongside the shell, which a
+	// signal that would end a process ends before its next command.
+	async bool
+	// killedBy is the signal that ended such a subshell.
+	killedBy syscall.Signal
+	// waiting is the job being waited for, whose subshells get the
+	// signals sent to the shell or subshell waiting. It is guarded by sigMu.
+	waiting []*proc
+
+	jobTable []*jobEntry
+	// reaped holds the exit statuses of the processes of jobs dropped from
+	// jobTable once done, latest last, for wait to return.
+	reaped      []reapedProc
+	interactive bool
+	shellPgid   int
+	shellTmodes *syscall.Termios
+	// jobGroups is set for a background subshell of an interactive shell,
+	// which puts each of its jobs in a process group of its own, away from
+	// the terminal.
+	jobGroups bool
+
+	hist *history
+}
+
+// start sets up the shell state from the configuration the first time the
+// runner is used.
+func (r *Runner) start() {
+	if r.started {
+		return
+	}
+	r.started = true
+	r.vars = map[string]*variable{}
+	r.functions = map[string]*FuncDecl{}
+	r.aliases = map[string]string{}
+	r.traps = map[syscall.Signal]string{}
+	r.hist = &history{}
+	r.scriptName, r.positional = r.Name, r.Params
+	if r.scriptName == "" {
+		r.scriptName = os.Args[0]
+	}
+	r.dir = r.Dir
+	if r.dir == "" {
+		r.dir, _ = os.Getwd()
+	}
+	r.dir, _ = filepath.Abs(r.dir)
+	r.initVars()
+}
+
+// connect points the shell's standard descriptors at Stdin, Stdout and
+// Stderr for the duration of a run. The returned function closes the pipes
+// it made and waits for their contents to be copied.
+func (r *Runner) connect() (func(), error) {
+	var closers []io.Closer
+	var wg sync.WaitGroup
+	done := func() {
+		for _, c := range closers {
+			c.Close()
+		}
+		wg.Wait()
+	}
+	in, err := inputFile(r.Stdin, &closers)
+	if err != nil {
+		done()
+		return nil, err
+	}
+	out, err := outputFile(r.Stdout, &closers, &wg)
+	if err != nil {
+		done()
+		return nil, err
+	}
+	errf := out
+	if r.Stderr != r.Stdout {
+		errf, err = outputFile(r.Stderr, &closers, &wg)
+		if err != nil {
+			done()
+			return nil, err
+		}
+	}
+	r.stdin, r.stdout, r.stderr = in, out, errf
+	return done, nil
+}
+
+func inputFile(rd io.Reader, closers *[]io.Closer) (*os.File, error) {
+	switch rd := rd.(type) {
+	case *os.File:
+		return rd, nil
+	case nil:
+		f, err := os.Open(os.DevNull)
+		if err == nil {
+			*closers = append(*closers, f)
+		}
+		return f, err
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	*closers = append(*closers, pr)
+	go func() {
+		_, _ = io.Copy(pw, rd)
+		pw.Close()
+	}()
+	return pr, nil
+}
+
+func outputFile(w io.Writer, closers *[]io.Closer, wg *sync.WaitGroup) (*os.File, error) {
+	switch w := w.(type) {
+	case *os.File:
+		return w, nil
+	case nil:
+		f, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		if err == nil {
+			*closers = append(*closers, f)
+		}
+		return f, err
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	*closers = append(*closers, pw)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(w, pr)
+		pr.Close()
+	}()
+	return pw, nil
+}
+
+// Run parses src as a whole and runs it, returning the exit status. A
+// syntax error anywhere stops src before any of it runs and is returned
+// with status 2. State carries over from one Run to the next, as it does
+// between the lines typed at an interactive shell.
+func (r *Runner) Run(src string) (int, error) {
+	r.start()
+	done, err := r.connect()
+	if err != nil {
+		return 1, err
+	}
+	defer done()
+	list, err := r.parse(src)
+	if err != nil {
+		return 2, err
+	}
+	r.interruptReq = false
+	status := r.runList(list)
+	if r.exiting {
+		return r.exitCode, nil
+	}
+	if r.interruptReq {
+		return 130, nil
+	}
+	return status, nil
+}
+
+// SetOption turns the option called name, as listed by set -o, on or off.
+func (r *Runner) SetOption(name string, on bool) error {
+	r.start()
+	if !r.setOption(name, on) {
+		return fmt.Errorf("%s: invalid option name", name)
+	}
+	return nil
+}
+
+// Exited reports whether the shell ran exit, after which it should not be
+// given more commands.
+func (r *Runner) Exited() bool {
+	return r.exiting
+}
+
+// Exit runs the EXIT trap, if one is set, and returns the status the shell
+// ends with: status, unless the trap itself ran exit.
+func (r *Runner) Exit(status int) int {
+	r.start()
+	action, ok := r.traps[0]
+	if !ok {
+		return status
+	}
+	delete(r.traps, 0)
+	done, err := r.connect()
+	if err != nil {
+		return status
+	}
+	defer done()
+	r.exiting = false
+	r.lastStatus = status
+	r.runTrap(action)
+	if r.exiting {
+		return r.exitCode
+	}
+	return status
+}
+
+// parse parses src with the shell's aliases.
+func (r *Runner) parse(src string) (*List, error) {
+	return parseWithAliases(src, r.aliases)
+}
+
+// open opens a file named in a redirection, relative to the working
+// directory, through OpenHandler if one is set. /dev/fd/N and /dev/stdin,
+// /dev/stdout and /dev/stderr name the shell's own descriptors, which are
+// not those of the process.
+func (r *Runner) open(path string, flag int, perm os.FileMode) (*os.File, error) {
+	path = r.path(path)
+	if n, ok := devFd(path); ok {
+		if fds := r.shellFds(); n < len(fds) && fds[n] != nil {
+			path = "/proc/self/fd/" + strconv.Itoa(int(fds[n].Fd()))
+		}
+	}
+	if r.OpenHandler != nil {
+		return r.OpenHandler(path, flag, perm)
+	}
+	return os.OpenFile(path, flag, perm)
+}
+
+// devFd returns the descriptor that path names under /dev.
+func devFd(path string) (int, bool) {
+	switch path {
+	case "/dev/stdin":
+		return 0, true
+	case "/dev/stdout":
+		return 1, true
+	case "/dev/stderr":
+		return 2, true
+	}
+	if s, ok := strings.CutPrefix(path, "/dev/fd/"); ok {
+		n, err := strconv.Atoi(s)
+		return n, err == nil && n >= 0
+	}
+	return 0, false
+}
+
+// path resolves name against the shell's working directory.
+func (r *Runner) path(name string) string {
+	if name == "" {
+		return name
+	}
+	return joinDir(r.dir, name)
+}
+
+// joinDir resolves name against dir unless it is already absolute.
+func joinDir(dir, name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(dir, name)
+}
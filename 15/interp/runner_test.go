@@ -0,0 +1,361 @@
+package interp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// runPipeline parses src, a single pipeline, and runs it with runJob.
+func runPipeline(t *testing.T, r *Runner, src string) int {
+	t.Helper()
+	r.start()
+	done, err := r.connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+	list, err := parseLine(src)
+	if err != nil {
+		t.Fatalf("parseLine(%q): %v", src, err)
+	}
+	return r.runJob(list.Items[0].Pipelines[0], false)
+}
+
+func TestRunJob(t *testing.T) {
+	tests := []struct {
+		input    string
+		pipefail bool
+		status   int
+		output   string
+	}{
+		{"echo hi", false, 0, "hi\n"},
+		{"false", false, 1, ""},
+		{"true | false", false, 1, ""},
+		{"false | true", false, 0, ""},
+		{"false | true", true, 1, ""},
+		{"! true", false, 1, ""},
+		{"echo a b | tr ab xy", false, 0, "x y\n"},
+		{"printf '%s-' 1 2 | cat", false, 0, "1-2-"},
+		{"echo saved >f.txt", false, 0, ""},
+		{"cat <f.txt", false, 0, "saved\n"},
+		{"pwd", false, 0, "DIR\n"},
+		{"sh -c pwd", false, 0, "DIR\n"},
+		{"nonexistent-command-x", false, 127, ""},
+		{"sh -c 'exit 3'", false, 3, ""},
+		{"sh -c 'kill -9 $$'", false, 137, ""},
+	}
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		r := &Runner{Stdout: &out, Dir: dir, Env: []string{"PATH=" + os.Getenv("PATH")}}
+		r.optPipefail = tt.pipefail
+		status := runPipeline(t, r, tt.input)
+		want := strings.ReplaceAll(tt.output, "DIR", dir)
+		if status != tt.status || out.String() != want {
+			t.Errorf("runJob(%q) = %d, %q; want %d, %q", tt.input, status, out.String(), tt.status, want)
+		}
+	}
+}
+
+func TestRunHandlers(t *testing.T) {
+	tests := []struct {
+		input  string
+		status int
+		output string
+		opened []string
+	}{
+		{"cmd a b >log.txt; echo x | cmd c", 2, "cmd c in /work with GREETING=hello\n", []string{"/work/log.txt"}},
+		{"echo x | while read l; do cmd $l; done", 2, "cmd x in /work with GREETING=hello\n", nil},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		var opened []string
+		r := &Runner{
+			Stdout: &out,
+			Dir:    "/work",
+			Env:    []string{"GREETING=hello"},
+			ExecHandler: func(cmd *Cmd) int {
+				v := ""
+				for _, kv := range cmd.Env {
+					if strings.HasPrefix(kv, "GREETING=") {
+						v = kv
+					}
+				}
+				fmt.Fprintf(cmd.Stdout, "%s in %s with %s\n", strings.Join(cmd.Args, " "), cmd.Dir, v)
+				_, _ = io.Copy(io.Discard, cmd.Stdin)
+				return len(cmd.Args)
+			},
+			OpenHandler: func(path string, flag int, perm os.FileMode) (*os.File, error) {
+				opened = append(opened, path)
+				return os.OpenFile(os.DevNull, flag&^(os.O_CREATE|os.O_TRUNC), perm)
+			},
+		}
+		status, err := r.Run(tt.input)
+		if err != nil {
+			t.Errorf("Run(%q) unexpected error: %v", tt.input, err)
+			continue
+		}
+		if status != tt.status || out.String() != tt.output {
+			t.Errorf("Run(%q) = %d, %q; want %d, %q", tt.input, status, out.String(), tt.status, tt.output)
+		}
+		if !slices.Equal(opened, tt.opened) {
+			t.Errorf("Run(%q) opened %q; want %q", tt.input, opened, tt.opened)
+		}
+	}
+}
+
+func TestRunOptions(t *testing.T) {
+	tests := []struct {
+		input  string
+		status int
+		output string
+		errors string
+	}{
+		{"set -e; false; echo no", 1, "", ""},
+		{"set -e; false || true; false && true; ! true; if false; then :; fi; echo yes", 0, "yes\n", ""},
+		{"set -e; f() { false; echo in; }; f || echo failed", 0, "in\n", ""},
+		{"set -e; (exit 3); echo no", 3, "", ""},
+		{"set -u; echo $unset; echo no", 127, "", "unset: unbound variable\n"},
+		{"set -u; echo $# $1", 127, "", "$1: unbound variable\n"},
+		{"set -x; x=1; echo \"a b\" $x", 0, "a b 1\n", "+ x=1\n+ echo 'a b' 1\n"},
+		{"PS4='> '; set -x; y=$(echo in)", 0, "", ">> echo in\n> y=in\n"},
+		{"set -eu; echo $-; set +eu -o xtrace; echo $-", 0, "eu\nx\n", "+ echo x\n"},
+		{"set -- a b c; shift; echo $@; shift 2; echo $# $?; shift; echo $?; shift -1", 1, "b c\n0 0\n1\n", "shift: -1: shift count out of range\n"},
+		{"echo hi >/dev/full; echo $?; printf x >/dev/full || echo $?", 0, "1\n1\n", "echo: write error: No space left on device\nprintf: write error: No space left on device\n"},
+		{"f() { f; }; f; echo $?", 0, "1\n", "f: maximum function nesting level exceeded\n"},
+		{"F=$(mktemp); echo '. $F' >$F; . $F; echo $?; rm $F", 0, "1\n", ".: maximum source nesting level exceeded\n"},
+	}
+
+	for _, tt := range tests {
+		var out, errs bytes.Buffer
+		r := &Runner{Stdout: &out, Stderr: &errs, Env: []string{"PATH=" + os.Getenv("PATH")}}
+		status, err := r.Run(tt.input)
+		if err != nil {
+			t.Errorf("Run(%q) unexpected error: %v", tt.input, err)
+			continue
+		}
+		if status != tt.status || out.String() != tt.output || errs.String() != tt.errors {
+			t.Errorf("Run(%q) = %d, %q, %q; want %d, %q, %q", tt.input, status, out.String(), errs.String(), tt.status, tt.output, tt.errors)
+		}
+	}
+}
+
+func TestRunTraps(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{"trap 'echo got' USR1; kill -USR1 $$; echo after", "got\nafter\n"},
+		{"(trap 'echo bye' EXIT; echo in); trap", "in\nbye\n"},
+		{"trap 'echo int' INT; trap - INT; trap", ""},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		r := &Runner{Stdout: &out, Env: []string{}}
+		if _, err := r.Run(tt.input); err != nil {
+			t.Errorf("Run(%q) unexpected error: %v", tt.input, err)
+			continue
+		}
+		if out.String() != tt.output {
+			t.Errorf("Run(%q) output %q; want %q", tt.input, out.String(), tt.output)
+		}
+	}
+}
+
+func TestRunSubshells(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{"x=5; echo hi | while read l; do echo $l $x; done", "hi 5\n"},
+		{"x=5; f(){ echo $x; }; f | cat", "5\n"},
+		{"x=5; { echo $x; } &", "5\n"},
+		{"false; echo | { echo $?; }", "1\n"},
+		{"a=(p q); set -- one two; echo | { echo ${a[1]} $2; }", "q two\n"},
+		{"x=1; echo | { x=2; cd /; }; echo $x; echo | exit 7; echo $?", "1\n7\n"},
+		{"sh -c 'exit 3' & wait $!; echo $?; { exit 4; } & wait %%; echo $?; sh -c 'kill -9 $$' & wait; echo $?; wait 1; echo $?", "3\n4\n0\n127\n"},
+		{"sh -c 'exit 5' & p=$!; sleep 0.1; jobs; wait $p; echo $?", "5\n"},
+		{"set -o pipefail; while :; do echo y; done | head -1; echo $?", "y\n141\n"},
+		{"f() { while :; do echo y; done; }; f | head -2", "y\ny\n"},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		r := &Runner{Stdout: &out, Env: []string{"PATH=" + os.Getenv("PATH")}}
+		if _, err := r.Run(tt.input); err != nil {
+			t.Errorf("Run(%q) unexpected error: %v", tt.input, err)
+			continue
+		}
+		if out.String() != tt.output {
+			t.Errorf("Run(%q) output %q; want %q", tt.input, out.String(), tt.output)
+		}
+	}
+}
+
+func TestRunSubst(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{"cat <(echo a) <(echo b)", "a\nb\n"},
+		{"diff <(printf 'b\\na\\n' | sort) <(printf 'a\\nb\\n') && echo same", "same\n"},
+		{"while read l; do echo got $l; done < <(printf '1\\n2\\n')", "got 1\ngot 2\n"},
+		{"echo x > >(tr x y)", "y\n"},
+		{"x=5; f() { echo $1; }; diff <(echo $x) <(f 5) && echo same", "same\n"},
+		{"echo <(true) >(true)", "/dev/fd/63 /dev/fd/62\n"},
+		{"coproc head -n1; echo hi >&${COPROC[1]}; read l <&${COPROC[0]}; echo $l ${COPROC[@]}", "hi 63 62\n"},
+		{"coproc UP { sed s/h/H/; }; echo ${UP[0]} ${UP[1]}", "63 62\n"},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		r := &Runner{Stdout: &out, Env: []string{"PATH=" + os.Getenv("PATH")}}
+		status, err := r.Run(tt.input)
+		if err != nil {
+			t.Errorf("Run(%q) unexpected error: %v", tt.input, err)
+			continue
+		}
+		if status != 0 || out.String() != tt.output {
+			t.Errorf("Run(%q) = %d, %q; want 0, %q", tt.input, status, out.String(), tt.output)
+		}
+	}
+}
+
+func TestRunExpansion(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{`x="a  b"; printf '<%s>' $x "$x"`, "<a><b><a  b>"},
+		{`set -- "p q" r; printf '<%s>' "$@" $@ "$*"`, "<p q><r><p><q><r><p q r>"},
+		{`set --; printf '<%s>' "$@" "a$@" $e "$e"`, "<a><>"},
+		{`IFS=:; p=/bin::/usr/bin; printf '<%s>' $p`, "</bin><></usr/bin>"},
+		{`IFS=', '; l='a, b,c ,, d'; printf '<%s>' $l`, "<a><b><c><><d>"},
+		{`IFS=; x='a b'; printf '<%s>' $x`, "<a b>"},
+		{`e=; echo "${u:-def}" ${u-d2} "${e:-empty}" "[${e-unset}]"`, "def d2 empty []\n"},
+		{`echo ${v:=set} $v; echo ${v:+alt} "[${u:+alt}]"`, "set set\nalt []\n"},
+		{`printf '<%s>' ${u:-"q  r"} ${u:-q  r}`, "<q  r><q><r>"},
+		{`f=path/to/file.tar.gz; echo ${#f} ${f#*/} ${f##*/} ${f%.*} ${f%%.*}`, "19 to/file.tar.gz file.tar.gz path/to/file.tar path/to/file\n"},
+		{`f=path/to/file; echo ${f/o/0} ${f//o/0} ${f/#path/P} ${f/%file/F} ${f//[aeiou]}`, "path/t0/file path/t0/file P/to/file path/to/F pth/t/fl\n"},
+		{`y='*'; f=a*b; echo ${f#$y} ${f#"$y"} ${f%'*b'}`, "a*b a*b a\n"},
+		{`a=(x "y z" w); echo ${a[1]} ${#a[@]} ${a[-1]} ${#a[1]} $a`, "y z 3 w 3 x\n"},
+		{`a=(x "y z"); printf '<%s>' "${a[@]}" ${a[@]} "${a[*]}"`, "<x><y z><x><y><z><x y z>"},
+		{`a=(1 2 3); i=2; a[i]=c; a[0]=a; echo ${a[@]} ${a[$i]} ${a[i-1]}`, "a 2 c c 2\n"},
+		{`a=(); echo ${#a[@]}; for x in "${a[@]}"; do echo no; done`, "0\n"},
+		{`a=(x y z); a[5]=q; echo ${#a[@]} ${a[@]} ${a[-1]} "${a[4]-unset}"`, "4 x y z q q unset\n"},
+		{`a=(x y z); unset 'a[1]'; i=2; unset "a[i]"; echo ${#a[@]} ${a[@]}`, "1 x\n"},
+		{`a=(1 2); i=0; echo $((a[1]+2)) $((a[i++])) $i $((a[i]++)) $((a[-1] *= 3)) ${a[@]}`, "4 1 1 2 9 1 9\n"},
+		{`i=0; : $(( b[i++] = 5, b[2] = b[0] + 1 )); echo $i ${b[@]} $((c[1]))`, "1 5 6 0\n"},
+		{`a[3000000000]=x; a[1]=y; echo ${#a[@]} ${a[@]} ${a[3000000000]}`, "2 y x x\n"},
+		{`echo $((2**62)) $((3**40)) $(((-2)**3)) $((0 && 2**1000000000000000000))`, "4611686018427387904 -6289078614652622815 -8 0\n"},
+		{`a=(x y); echo ${a[@]/x/X} ${a[@]#y}`, "X y x\n"},
+		{`g() { local l=$1; echo "[$l]"; }; g "m  n"`, "[m  n]\n"},
+		{`echo ${u:?is missing}; echo no`, ""},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		r := &Runner{Stdout: &out, Env: []string{}}
+		if _, err := r.Run(tt.input); err != nil {
+			t.Errorf("Run(%q) unexpected error: %v", tt.input, err)
+			continue
+		}
+		if out.String() != tt.output {
+			t.Errorf("Run(%q) output %q; want %q", tt.input, out.String(), tt.output)
+		}
+	}
+}
+
+func TestRunResources(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+		errors string
+	}{
+		{"time -p true 2>/dev/null", "", "real 0.00\nuser 0.00\nsys 0.00\n"},
+		{"times | wc -l", "2\n", ""},
+		{"(ulimit -f 10; ulimit -f; ulimit -n 100; sh -c 'ulimit -n'); x=$(ulimit -f 20); ulimit -f", "10\n100\nunlimited\n", ""},
+		{"ulimit -n 50; echo | { ulimit -n; }; sh -c 'ulimit -n' | cat", "50\n50\n", ""},
+		{"ulimit -H -n 50; ulimit -n 60", "", "ulimit: open files: cannot modify limit: invalid argument\n"},
+		{"ulimit -f x", "", "ulimit: x: invalid number\n"},
+		{"ulimit -f 1 2", "", "ulimit: too many arguments\n"},
+	}
+
+	for _, tt := range tests {
+		var out, errs bytes.Buffer
+		r := &Runner{Stdout: &out, Stderr: &errs, Env: []string{"PATH=" + os.Getenv("PATH")}}
+		if _, err := r.Run(tt.input); err != nil {
+			t.Errorf("Run(%q) unexpected error: %v", tt.input, err)
+			continue
+		}
+		if out.String() != tt.output || errs.String() != tt.errors {
+			t.Errorf("Run(%q) = %q, %q; want %q, %q", tt.input, out.String(), errs.String(), tt.output, tt.errors)
+		}
+	}
+}
+
+func TestRunAudit(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "audit.log")
+	r := &Runner{Env: []string{"PATH=" + os.Getenv("PATH")}}
+	if err := r.SetAudit(log); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Run("x=1; y=2 echo a >/dev/null | cat; ! false; sleep 0 &"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"[x=1] [] 0 => 0",
+		"[y=2] [echo a] 1>/dev/null 0 | [] [cat] pid 0 => 0",
+		"[] [false] 1 => 0",
+		"[] [sleep 0] pid <nil> => <nil>",
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("audit log has %d lines; want %d:\n%s", len(lines), len(want), data)
+	}
+	for i, line := range lines {
+		var rec auditRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("line %d: %v", i+1, err)
+		}
+		var cmds []string
+		for _, c := range rec.Cmds {
+			s := fmt.Sprint(c.Assigns, " ", c.Args)
+			for _, rd := range c.Redirs {
+				s += fmt.Sprintf(" %d%s%s", rd.Fd, rd.Op, rd.Target)
+			}
+			if c.Pid > 0 {
+				s += " pid"
+			}
+			cmds = append(cmds, s+" "+fmtStatus(c.Status))
+		}
+		got := strings.Join(cmds, " | ") + " => " + fmtStatus(rec.Status)
+		if got != want[i] || rec.Dir == "" || rec.Time.IsZero() {
+			t.Errorf("line %d = %s in %q; want %s", i+1, got, rec.Dir, want[i])
+		}
+	}
+}
+
+func fmtStatus(status *int) string {
+	if status == nil {
+		return "<nil>"
+	}
+	return fmt.Sprint(*status)
+}
@@ -0,0 +1,649 @@
+package interp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Cmd is a simple command after expansion: its words, the NAME=value
+// assignments before it and its redirections.
+type Cmd struct {
+	Args   []string
+	Env    []string
+	Redirs []Redir
+
+	// Dir is the working directory, set for ExecHandler.
+	Dir string
+
+	// Stdin, Stdout and Stderr are the streams a builtin reads and writes.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// arrays are the NAME=(...) and NAME[index]=value assignments, which
+	// only take effect in a command made of assignments alone.
+	arrays []arrayAssign
+	// compound is set, and the rest left empty, for a pipeline member that
+	// is not a simple command.
+	compound Command
+}
+
+// RunInteractive reads commands from Stdin and runs them until end of input
+// or exit, editing lines on the terminal when job control is enabled.
+func (r *Runner) RunInteractive() int {
+	r.start()
+	done, err := r.connect()
+	if err != nil {
+		if r.Stderr != nil {
+			fmt.Fprintln(r.Stderr, err)
+		}
+		return 1
+	}
+	defer done()
+	reader := bufio.NewReader(r.stdin)
+	var ed *lineEditor
+	if r.interactive {
+		r.loadHistory()
+		ed = &lineEditor{fd: ttyFd, out: r.stdout, hist: r.hist, completions: r.completions}
+	}
+	buf := ""
+	for {
+		if buf == "" {
+			r.reapJobs()
+		}
+		var line string
+		var err error
+		if ed != nil {
+			line, err = ed.readLine(r.prompt(buf != ""))
+		} else {
+			line, err = reader.ReadString('\n')
+		}
+		if errors.Is(err, errInterrupted) {
+			buf = ""
+			r.lastStatus = 130
+			continue
+		}
+		if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+			if errors.Is(err, io.EOF) {
+				if buf != "" {
+					if _, perr := r.parse(buf); perr != nil {
+						fmt.Fprintln(r.stderr, "parse error:", perr)
+						r.lastStatus = 2
+					}
+				}
+				if r.interactive {
+					fmt.Fprintln(r.stdout)
+				}
+				return r.lastStatus
+			}
+			fmt.Fprintln(r.stderr, "read error:", err)
+			continue
+		}
+		if ed != nil {
+			expanded, err := r.hist.expand(line)
+			if err != nil {
+				fmt.Fprintln(r.stderr, err)
+				buf = ""
+				continue
+			}
+			if expanded != line {
+				fmt.Fprint(r.stdout, expanded)
+				line = expanded
+			}
+			r.hist.add(line)
+		}
+		if buf == "" && strings.TrimSpace(line) == "" {
+			continue
+		}
+		buf += strings.TrimSuffix(line, "\n") + "\n"
+
+		list, perr := r.parse(buf)
+		if perr != nil {
+			var se *SyntaxError
+			if errors.As(perr, &se) && se.Incomplete {
+				continue
+			}
+			fmt.Fprintln(r.stderr, "parse error:", perr)
+			r.lastStatus = 2
+			buf = ""
+			continue
+		}
+		buf = ""
+		r.interruptReq = false
+		r.runList(list)
+		if r.exiting {
+			if r.interactive {
+				fmt.Fprintln(r.stderr, "exit")
+			}
+			return r.exitCode
+		}
+	}
+}
+
+func (r *Runner) runList(l *List) int {
+	status := 0
+	for _, ao := range l.Items {
+		status = r.runAndOr(ao)
+		// Background jobs that have finished are reaped as soon as possible,
+		// but an interactive shell only tells of them before its prompt.
+		if r.interactive {
+			r.pollJobs()
+		} else {
+			r.reapJobs()
+		}
+		r.checkSignals()
+		if r.interrupted() {
+			break
+		}
+	}
+	return status
+}
+
+// needsSubshell reports whether the command args, as part of a longer
+// pipeline, has to run in a subshell so that it cannot change this one.
+func (r *Runner) needsSubshell(args []string) bool {
+	return len(args) > 0 && r.functions[args[0]] != nil || isBuiltin(args) && altersShell(args[0])
+}
+
+// runInBackground runs fn in a subshell in the background, so that a whole
+// and-or list or compound command such as `a && b &`, spelled text, becomes
+// one job. Its standard input is the null device.
+func (r *Runner) runInBackground(text string, fn func(sub *Runner) int) int {
+	null, err := os.Open(os.DevNull)
+	if err != nil {
+		fmt.Fprintln(r.stderr, "background:", err)
+		return 1
+	}
+	fds := r.shellFds()
+	fds[0] = null
+	sub := r.subshell(fds)
+	sub.jobGroups = r.jobGroups || r.interactive
+	p := goSubshell(sub, fn, []*os.File{null})
+	j := &jobEntry{procs: []*proc{p}, text: text}
+	r.addJob(j)
+	r.lastBgPid = p.pid
+	if r.interactive {
+		fmt.Fprintf(r.stdout, "[%d] %d\n", j.id, p.pid)
+	}
+	return 0
+}
+
+// runCoproc starts the command of c in a subshell in the background, with
+// pipes to its standard input and output. The shell's ends are put in its
+// descriptor table and their numbers in the array NAME, the one to read
+// from first, and the subshell's pid in NAME_PID. Like bash, the shell
+// keeps those descriptors from the commands it runs unless they are
+// redirected.
+func (r *Runner) runCoproc(c *Coproc) int {
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		fmt.Fprintln(r.stderr, "coproc:", err)
+		return 1
+	}
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		inR.Close()
+		inW.Close()
+		fmt.Fprintln(r.stderr, "coproc:", err)
+		return 1
+	}
+	text := strings.TrimSpace(c.Text[commandPos(c.Cmd).Offset-c.Pos.Offset:])
+	pl := &Pipeline{Pos: commandPos(c.Cmd), Cmds: []Command{c.Cmd}, Text: text}
+	fds := r.shellFds()
+	fds[0], fds[1] = inR, outW
+	sub := r.subshell(fds)
+	sub.jobGroups = r.jobGroups || r.interactive
+	p := goSubshell(sub, func(sub *Runner) int {
+		return sub.runJob(pl, false)
+	}, []*os.File{inR, outW})
+	j := &jobEntry{procs: []*proc{p}, text: c.Text}
+	r.addJob(j)
+	r.lastBgPid = p.pid
+
+	if r.coprocFds == nil {
+		r.coprocFds = map[*os.File]bool{}
+	}
+	r.coprocFds[outR], r.coprocFds[inW] = true, true
+	readFd, writeFd := r.addShellFd(outR), r.addShellFd(inW)
+	r.setArray(c.Name, []string{strconv.Itoa(readFd), strconv.Itoa(writeFd)})
+	r.setVar(c.Name+"_PID", strconv.Itoa(p.pid))
+	if r.interactive {
+		fmt.Fprintf(r.stdout, "[%d] %d\n", j.id, p.pid)
+	}
+	return 0
+}
+
+func (r *Runner) runAndOr(ao *AndOr) int {
+	if ao.Background && len(ao.Pipelines) > 1 {
+		fg := *ao
+		fg.Background = false
+		return r.runInBackground(ao.Text, func(sub *Runner) int {
+			return sub.runAndOr(&fg)
+		})
+	}
+	status := 0
+	last := len(ao.Pipelines) - 1
+	for i, pl := range ao.Pipelines {
+		if i > 0 {
+			if ao.Ops[i-1] == "&&" && status != 0 {
+				continue
+			}
+			if ao.Ops[i-1] == "||" && status == 0 {
+				continue
+			}
+		}
+		ignored := i < last || pl.Bang
+		if ignored {
+			r.errexitIgnored++
+		}
+		status = r.runJob(pl, ao.Background)
+		if ignored {
+			r.errexitIgnored--
+		} else {
+			r.errexit(status)
+		}
+		r.lastStatus = status
+		if r.interrupted() {
+			break
+		}
+	}
+	return status
+}
+
+func (r *Runner) runJob(pl *Pipeline, background bool) int {
+	if pl.Time {
+		if background {
+			// A subshell times the pipeline in the background.
+			return r.runInBackground(pl.Text, func(sub *Runner) int {
+				return sub.timeJob(pl)
+			})
+		}
+		return r.timeJob(pl)
+	}
+	defer r.closeProcSubsts(len(r.procSubsts))
+	var status int
+	// A compound command run by the shell itself is not recorded in the
+	// audit log, but the pipelines inside it are.
+	var rec *auditRecord
+	if _, simple := pl.Cmds[0].(*SimpleCommand); len(pl.Cmds) == 1 && !simple {
+		if background {
+			return r.runInBackground(pl.Text, func(sub *Runner) int {
+				return sub.runCompound(pl.Cmds[0])
+			})
+		}
+		status = r.runCompound(pl.Cmds[0])
+	} else {
+		rec = r.newAudit(background)
+		status = r.execPipeline(pl, background, rec)
+	}
+	if pl.Bang && !background {
+		if status == 0 {
+			status = 1
+		} else {
+			status = 0
+		}
+	}
+	r.writeAudit(rec, status)
+	return status
+}
+
+// execPipeline runs the commands of pl, describing them in rec unless it
+// is nil.
+func (r *Runner) execPipeline(pl *Pipeline, background bool, rec *auditRecord) int {
+	r.substStatus = 0
+	var cmdList []*Cmd
+	for _, c := range pl.Cmds {
+		sc, ok := c.(*SimpleCommand)
+		if !ok {
+			cmd := &Cmd{compound: c}
+			rec.addCmd(cmd)
+			cmdList = append(cmdList, cmd)
+			continue
+		}
+		cmd, err := r.expandCmd(sc)
+		if err != nil {
+			fmt.Fprintln(r.stderr, err)
+			return 1
+		}
+		if r.optXtrace {
+			r.trace(cmd)
+		}
+		rec.addCmd(cmd)
+		cmdList = append(cmdList, cmd)
+	}
+
+	if len(cmdList) == 1 && len(cmdList[0].Args) == 0 {
+		return rec.ran(r.withRedirs(cmdList[0].Redirs, func() int {
+			for _, kv := range cmdList[0].Env {
+				name, value, _ := strings.Cut(kv, "=")
+				r.setVar(name, value)
+			}
+			for _, aa := range cmdList[0].arrays {
+				if err := r.assignArray(aa); err != nil {
+					fmt.Fprintln(r.stderr, err)
+					return 1
+				}
+			}
+			return r.substStatus
+		}))
+	}
+	if len(cmdList) == 1 && r.functions[cmdList[0].Args[0]] != nil {
+		c := cmdList[0]
+		return rec.ran(r.withRedirs(c.Redirs, func() int {
+			return r.withTempVars(c.Env, func() int {
+				return r.callFunction(r.functions[c.Args[0]], c.Args)
+			})
+		}))
+	}
+	if len(cmdList) == 1 && isBuiltin(cmdList[0].Args) {
+		c := cmdList[0]
+		return rec.ran(r.withRedirs(c.Redirs, func() int {
+			c.Stdin, c.Stdout, c.Stderr = r.stdin, r.stdout, r.stderr
+			return r.withTempVars(c.Env, func() int {
+				return r.runBuiltin(c)
+			})
+		}))
+	}
+
+	n := len(cmdList)
+	pipes := make([]*os.File, 2*(n-1))
+	for i := 0; i < n-1; i++ {
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			fmt.Fprintln(r.stderr, "pipe error:", err)
+			return 1
+		}
+		pipes[2*i] = pr
+		pipes[2*i+1] = pw
+	}
+	var opened []*os.File
+	defer func() {
+		closeFiles(pipes)
+		closeFiles(opened)
+	}()
+
+	// A subshell cannot be moved to another process group, so a foreground
+	// job with one stays in the shell's group, which keeps the terminal,
+	// and the shell passes Ctrl-C on to it.
+	setpgid := r.jobGroups || r.interactive && (background || !slices.ContainsFunc(cmdList, func(c *Cmd) bool {
+		return c.compound != nil || r.needsSubshell(c.Args)
+	}))
+	j := &jobEntry{text: pl.Text}
+	defer func() { rec.addProcs(j.procs) }()
+	for i, c := range cmdList {
+		fds := r.shellFds()
+		if i > 0 {
+			fds[0] = pipes[2*(i-1)]
+		} else if background {
+			null, err := os.Open(os.DevNull)
+			if err == nil {
+				opened = append(opened, null)
+			}
+			fds[0] = null
+		}
+		if i < n-1 {
+			fds[1] = pipes[2*i+1]
+		}
+		fds, files, err := r.applyRedirs(fds, c.Redirs)
+		if err != nil {
+			closeFiles(files)
+			fmt.Fprintln(r.stderr, err)
+			j.procs = append(j.procs, failedProc(1))
+			continue
+		}
+		inSubshell := c.compound != nil || r.needsSubshell(c.Args)
+		handled := !isBuiltin(c.Args) && len(c.Args) > 0 && r.ExecHandler != nil
+		if inSubshell || isBuiltin(c.Args) || handled {
+			// The builtin runs alongside the rest of the pipeline and owns
+			// its pipe ends, closing them when it returns so its neighbours
+			// see end of file. So do a subshell and a command given to
+			// ExecHandler.
+			if i > 0 {
+				files = append(files, pipes[2*(i-1)])
+				pipes[2*(i-1)] = nil
+			}
+			if i < n-1 {
+				files = append(files, pipes[2*i+1])
+				pipes[2*i+1] = nil
+			}
+			if inSubshell {
+				sub := r.subshell(fds)
+				sub.jobGroups = setpgid
+				j.procs = append(j.procs, goSubshell(sub, func(sub *Runner) int {
+					return sub.runPipelinePart(c)
+				}, files))
+				continue
+			}
+			c.Stdin, c.Stdout, c.Stderr = fds[0], fds[1], fds[2]
+			if handled {
+				c.Env, c.Dir = r.environ(c.Env), r.dir
+			}
+			p := &proc{result: make(chan int, 1)}
+			go func(c *Cmd, files []*os.File) {
+				var status int
+				if handled {
+					status = r.ExecHandler(c)
+				} else {
+					var broken bool
+					status, broken = r.runBuiltinPipe(c)
+					if broken {
+						p.killedBy = syscall.SIGPIPE
+					}
+				}
+				closeFiles(files)
+				p.result <- status
+			}(c, files)
+			j.procs = append(j.procs, p)
+			continue
+		}
+		opened = append(opened, files...)
+		if len(c.Args) == 0 {
+			// Assignments alone in a pipeline have no effect.
+			j.procs = append(j.procs, failedProc(0))
+			continue
+		}
+		cmd := &exec.Cmd{Args: c.Args, Env: r.environ(c.Env), Dir: r.dir}
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: setpgid, Pgid: j.pgid}
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = fds[0], fds[1], fds[2]
+		cmd.ExtraFiles = r.inheritedFds(fds[3:])
+
+		path, err := r.lookPath(c.Args[0], c.Env)
+		if err != nil {
+			fmt.Fprintf(cmd.Stderr, "%s: %v\n", c.Args[0], err)
+			j.procs = append(j.procs, failedProc(127))
+			continue
+		}
+		cmd.Path = path
+		if j.pgid == 0 && setpgid && r.interactive && !background {
+			cmd.SysProcAttr.Foreground = true
+			cmd.SysProcAttr.Ctty = ttyFd
+		}
+		p, err := r.startCmd(cmd)
+		if err != nil {
+			fmt.Fprintf(cmd.Stderr, "%s: %v\n", c.Args[0], errors.Unwrap(err))
+			j.procs = append(j.procs, failedProc(126))
+			continue
+		}
+		if j.pgid == 0 && setpgid {
+			j.pgid = p.pid
+		}
+		j.procs = append(j.procs, p)
+	}
+
+	closeFiles(pipes)
+
+	if j.state() == jobDone {
+		return j.exitStatus(r.optPipefail)
+	}
+	if background {
+		r.addJob(j)
+		for _, p := range j.procs {
+			if p.pid > 0 {
+				r.lastBgPid = p.pid
+			}
+		}
+		if r.interactive {
+			fmt.Fprintf(r.stdout, "[%d] %d\n", j.id, r.lastBgPid)
+		}
+		return 0
+	}
+
+	return r.waitForeground(j)
+}
+
+// failedProc stands in for a pipeline member that could not be started,
+// or that had no command to run.
+func failedProc(status int) *proc {
+	return &proc{done: true, status: syscall.WaitStatus(status << 8)}
+}
+
+// builtinNames lists the commands the shell runs itself.
+var builtinNames = []string{
+	".", ":", "[", "alias", "bg", "break", "cd", "continue", "dirs", "echo",
+	"exit", "export", "false", "fg", "history", "jobs", "kill", "local",
+	"popd", "printf", "ps", "pushd", "pwd", "read", "return", "set", "shift",
+	"source", "test", "times", "trap", "true", "type", "ulimit", "unalias",
+	"unset", "wait", "which",
+}
+
+func isBuiltin(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	for _, name := range builtinNames {
+		if args[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// altersShell reports whether the builtin name changes the state of the
+// shell itself. Inside a pipeline such a builtin runs in a subshell, so
+// that `cd /tmp | cat` leaves the working directory alone.
+func altersShell(name string) bool {
+	switch name {
+	case "cd", "fg", "bg", "break", "continue", "export", "unset",
+		"alias", "unalias", "local", "return", "source", ".", "set", "trap",
+		"exit", "read", "pushd", "popd", "ulimit", "shift", "wait":
+		return true
+	}
+	return false
+}
+
+// runBuiltin runs the builtin cmd. A builtin whose output cannot be
+// written says so and fails, as `echo hi >/dev/full` does. One that writes
+// to a broken pipe in a subshell ends the subshell as SIGPIPE would end a
+// subshell process, so that `while :; do echo y; done | head -1` stops.
+func (r *Runner) runBuiltin(cmd *Cmd) int {
+	status, broken := r.runBuiltinPipe(cmd)
+	if broken && r.async {
+		r.exiting, r.exitCode, r.killedBy = true, status, syscall.SIGPIPE
+	}
+	return status
+}
+
+// runBuiltinPipe runs the builtin cmd as runBuiltin does, but leaves it to
+// the caller to act on a write to a broken pipe, which it reports with the
+// status of a process killed by SIGPIPE.
+func (r *Runner) runBuiltinPipe(cmd *Cmd) (status int, broken bool) {
+	if len(cmd.Args) == 0 {
+		return 0, false
+	}
+	out := &errWriter{w: cmd.Stdout}
+	cmd.Stdout = out
+	status = r.builtin(cmd)
+	cmd.Stdout = out.w
+	switch {
+	case out.err == nil:
+		return status, false
+	case errors.Is(out.err, syscall.EPIPE):
+		return 128 + int(syscall.SIGPIPE), true
+	}
+	fmt.Fprintf(cmd.Stderr, "%s: write error: %s\n", cmd.Args[0], errorDescription(out.err))
+	return 1, false
+}
+
+func (r *Runner) builtin(cmd *Cmd) int {
+	switch cmd.Args[0] {
+	case "cd":
+		return r.builtinCd(cmd)
+	case "pwd":
+		return r.builtinPwd(cmd)
+	case "pushd":
+		return r.builtinPushd(cmd)
+	case "popd":
+		return r.builtinPopd(cmd)
+	case "dirs":
+		return r.builtinDirs(cmd)
+	case "echo":
+		args := cmd.Args[1:]
+		fmt.Fprintln(cmd.Stdout, strings.Join(args, " "))
+		return 0
+	case "kill":
+		return r.builtinKill(cmd)
+	case "break", "continue":
+		return r.builtinLoopControl(cmd)
+	case "export":
+		return r.builtinExport(cmd)
+	case "unset":
+		return r.builtinUnset(cmd)
+	case "alias":
+		return r.builtinAlias(cmd)
+	case "unalias":
+		return r.builtinUnalias(cmd)
+	case "local":
+		return r.builtinLocal(cmd)
+	case "return":
+		return r.builtinReturn(cmd)
+	case "source", ".":
+		return r.builtinSource(cmd)
+	case "history":
+		return r.builtinHistory(cmd)
+	case "set":
+		return r.builtinSet(cmd)
+	case "shift":
+		return r.builtinShift(cmd)
+	case "exit":
+		return r.builtinExit(cmd)
+	case ":", "true":
+		return 0
+	case "false":
+		return 1
+	case "test", "[":
+		return r.builtinTest(cmd)
+	case "printf":
+		return builtinPrintf(cmd)
+	case "read":
+		return r.builtinRead(cmd)
+	case "type":
+		return r.builtinType(cmd)
+	case "which":
+		return r.builtinWhich(cmd)
+	case "trap":
+		return r.builtinTrap(cmd)
+	case "jobs":
+		return r.builtinJobs(cmd)
+	case "fg":
+		return r.builtinFg(cmd)
+	case "bg":
+		return r.builtinBg(cmd)
+	case "wait":
+		return r.builtinWait(cmd)
+	case "ps":
+		return builtinPs(cmd)
+	case "times":
+		return builtinTimes(cmd)
+	case "ulimit":
+		return r.builtinUlimit(cmd)
+	}
+	return 0
+}
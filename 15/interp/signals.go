@@ -0,0 +1,214 @@
+package interp
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// signalNames lists the signals by number, without their SIG prefix.
+var signalNames = []struct {
+	name string
+	sig  syscall.Signal
+}{
+	{"HUP", syscall.SIGHUP}, {"INT", syscall.SIGINT}, {"QUIT", syscall.SIGQUIT},
+	{"ILL", syscall.SIGILL}, {"TRAP", syscall.SIGTRAP}, {"ABRT", syscall.SIGABRT},
+	{"BUS", syscall.SIGBUS}, {"FPE", syscall.SIGFPE}, {"KILL", syscall.SIGKILL},
+	{"USR1", syscall.SIGUSR1}, {"SEGV", syscall.SIGSEGV}, {"USR2", syscall.SIGUSR2},
+	{"PIPE", syscall.SIGPIPE}, {"ALRM", syscall.SIGALRM}, {"TERM", syscall.SIGTERM},
+	{"STKFLT", syscall.SIGSTKFLT}, {"CHLD", syscall.SIGCHLD}, {"CONT", syscall.SIGCONT},
+	{"STOP", syscall.SIGSTOP}, {"TSTP", syscall.SIGTSTP}, {"TTIN", syscall.SIGTTIN},
+	{"TTOU", syscall.SIGTTOU}, {"URG", syscall.SIGURG}, {"XCPU", syscall.SIGXCPU},
+	{"XFSZ", syscall.SIGXFSZ}, {"VTALRM", syscall.SIGVTALRM}, {"PROF", syscall.SIGPROF},
+	{"WINCH", syscall.SIGWINCH}, {"IO", syscall.SIGIO}, {"PWR", syscall.SIGPWR},
+	{"SYS", syscall.SIGSYS},
+}
+
+// parseSignal accepts a signal number or a name with or without its SIG
+// prefix, in any case. EXIT is signal 0.
+func parseSignal(s string) (syscall.Signal, bool) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n == 0 || signalName(syscall.Signal(n)) != "" {
+			return syscall.Signal(n), true
+		}
+		return 0, false
+	}
+	s = strings.TrimPrefix(strings.ToUpper(s), "SIG")
+	if s == "EXIT" {
+		return 0, true
+	}
+	for _, e := range signalNames {
+		if e.name == s {
+			return e.sig, true
+		}
+	}
+	return 0, false
+}
+
+func signalName(sig syscall.Signal) string {
+	if sig == 0 {
+		return "EXIT"
+	}
+	for _, e := range signalNames {
+		if e.sig == sig {
+			return e.name
+		}
+	}
+	return ""
+}
+
+// CatchSignals makes the shell catch SIGINT and SIGQUIT rather than die of
+// them, as a shell process should. Foreground jobs get their own process
+// group, so the terminal sends them Ctrl-C and Ctrl-\ directly; a script
+// shares its group with its children and stops once they have been handled.
+//
+// Signal handling belongs to the whole process, so a runner leaves it alone
+// unless this or EnableJobControl is called. Until then the actions set by
+// trap only run for signals the shell sends itself with kill.
+func (r *Runner) CatchSignals() {
+	r.start()
+	r.catchSignals()
+	signal.Notify(r.sigc, syscall.SIGINT, syscall.SIGQUIT)
+}
+
+// catchSignals sets up sigc, which queues the signals that arrive on it.
+func (r *Runner) catchSignals() {
+	if r.sigc != nil {
+		return
+	}
+	r.sigc = make(chan os.Signal, 4)
+	go func() {
+		for sig := range r.sigc {
+			r.queueSignal(sig.(syscall.Signal))
+			if sig == syscall.SIGINT || sig == syscall.SIGQUIT {
+				// Ctrl-C and Ctrl-\ reach the processes of the job in
+				// the foreground, but its subshells only through the shell.
+				r.signalSubshells(sig.(syscall.Signal))
+			}
+		}
+	}()
+}
+
+// queueSignal records sig for the next checkSignals.
+func (r *Runner) queueSignal(sig syscall.Signal) {
+	r.sigMu.Lock()
+	r.pending = append(r.pending, sig)
+	r.sigMu.Unlock()
+}
+
+// hasTrap reports whether trap has set an action for sig.
+func (r *Runner) hasTrap(sig syscall.Signal) bool {
+	_, ok := r.traps[sig]
+	return ok
+}
+
+// shellCatches reports whether the shell catches sig even without a trap.
+func (r *Runner) shellCatches(sig syscall.Signal) bool {
+	return sig == syscall.SIGINT || sig == syscall.SIGQUIT || sig == syscall.SIGTERM && r.interactive
+}
+
+// checkSignals acts on the signals that arrived since it was last called:
+// a trapped signal runs its action and an untrapped SIGINT interrupts the
+// commands in progress. It runs between commands, on the main goroutine.
+func (r *Runner) checkSignals() {
+	r.sigMu.Lock()
+	sigs := r.pending
+	r.pending = nil
+	r.sigMu.Unlock()
+	for _, sig := range sigs {
+		if action, ok := r.traps[sig]; ok {
+			r.runTrap(action)
+			continue
+		}
+		switch {
+		case r.async && terminates(sig):
+			// A subshell dies of it, as its process would.
+			r.exiting, r.exitCode = true, 128+int(sig)
+			r.killedBy = sig
+		case sig == syscall.SIGINT:
+			r.interruptReq = true
+		}
+	}
+}
+
+// setTraps replaces the traps, which the goroutines signalling a subshell
+// read.
+func (r *Runner) setTraps(traps map[syscall.Signal]string) {
+	r.sigMu.Lock()
+	r.traps = traps
+	r.sigMu.Unlock()
+}
+
+// runTrap runs action in the current shell, leaving $? as it was.
+func (r *Runner) runTrap(action string) {
+	if action == "" || r.inTrap {
+		return
+	}
+	list, err := r.parse(action)
+	if err != nil {
+		fmt.Fprintln(r.stderr, "trap:", err)
+		return
+	}
+	saved := r.lastStatus
+	r.inTrap = true
+	r.runList(list)
+	r.inTrap = false
+	r.lastStatus = saved
+}
+
+func (r *Runner) builtinTrap(cmd *Cmd) int {
+	args := cmd.Args[1:]
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 || args[0] == "-p" {
+		sigs := make([]int, 0, len(r.traps))
+		for sig := range r.traps {
+			sigs = append(sigs, int(sig))
+		}
+		sort.Ints(sigs)
+		for _, sig := range sigs {
+			fmt.Fprintf(cmd.Stdout, "trap -- %s %s\n", shellQuote(r.traps[syscall.Signal(sig)]), signalName(syscall.Signal(sig)))
+		}
+		return 0
+	}
+	action, names := args[0], args[1:]
+	if _, err := strconv.Atoi(action); err == nil || len(names) == 0 {
+		// trap N... resets the listed signals.
+		action, names = "-", args
+	}
+	status := 0
+	for _, name := range names {
+		sig, ok := parseSignal(name)
+		if !ok {
+			fmt.Fprintf(cmd.Stderr, "trap: %s: invalid signal specification\n", name)
+			status = 1
+			continue
+		}
+		if sig == syscall.SIGKILL || sig == syscall.SIGSTOP {
+			fmt.Fprintf(cmd.Stderr, "trap: %s: cannot be trapped\n", name)
+			status = 1
+			continue
+		}
+		if action == "-" {
+			r.sigMu.Lock()
+			delete(r.traps, sig)
+			r.sigMu.Unlock()
+			if r.sigc != nil && sig != 0 && !r.shellCatches(sig) {
+				signal.Reset(sig)
+			}
+			continue
+		}
+		r.sigMu.Lock()
+		r.traps[sig] = action
+		r.sigMu.Unlock()
+		if r.sigc != nil && sig != 0 {
+			signal.Notify(r.sigc, sig)
+		}
+	}
+	return status
+}
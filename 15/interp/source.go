@@ -0,0 +1,118 @@
+package interp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LoadRC runs ~/.goshrc, if there is one, at the start of an interactive
+// shell and returns its status.
+func (r *Runner) LoadRC() int {
+	r.start()
+	home, _ := r.getVar("HOME")
+	if home == "" {
+		return 0
+	}
+	path := filepath.Join(home, ".goshrc")
+	if _, err := os.Stat(path); err != nil {
+		return 0
+	}
+	done, err := r.connect()
+	if err != nil {
+		return 1
+	}
+	defer done()
+	status, err := r.sourceFile(path, nil)
+	if err != nil {
+		fmt.Fprintln(r.stderr, err)
+	}
+	if r.exiting {
+		return r.exitCode
+	}
+	return status
+}
+
+// sourceFile runs the commands in path in the current shell. When args are
+// given they become the positional parameters for the duration.
+func (r *Runner) sourceFile(path string, args []string) (int, error) {
+	data, err := r.readFile(path)
+	if err != nil {
+		var pe *os.PathError
+		if errors.As(err, &pe) {
+			err = fmt.Errorf("%s: %v", path, pe.Err)
+		}
+		return 1, err
+	}
+	list, err := r.parse(string(data))
+	if err != nil {
+		return 2, fmt.Errorf("%s: %v", path, err)
+	}
+	if args != nil {
+		saved := r.positional
+		r.positional = args
+		defer func() { r.positional = saved }()
+	}
+	r.sourceDepth++
+	defer func() {
+		r.sourceDepth--
+		r.returning = false
+	}()
+	return r.runList(list), nil
+}
+
+// readFile reads the whole of the file at path, opened as a redirection
+// would open it.
+func (r *Runner) readFile(path string) ([]byte, error) {
+	f, err := r.open(path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (r *Runner) builtinSource(cmd *Cmd) int {
+	if len(cmd.Args) < 2 {
+		fmt.Fprintf(cmd.Stderr, "%s: filename argument required\n", cmd.Args[0])
+		return 2
+	}
+	if r.sourceDepth >= maxNesting {
+		fmt.Fprintf(cmd.Stderr, "%s: maximum source nesting level exceeded\n", cmd.Args[0])
+		return 1
+	}
+	path := cmd.Args[1]
+	if !strings.Contains(path, "/") {
+		if p, err := r.lookSource(path); err == nil {
+			path = p
+		}
+	}
+	var args []string
+	if len(cmd.Args) > 2 {
+		args = cmd.Args[2:]
+	}
+	status, err := r.sourceFile(path, args)
+	if err != nil {
+		fmt.Fprintf(cmd.Stderr, "%s: %v\n", cmd.Args[0], err)
+	}
+	return status
+}
+
+// lookSource finds a file named on the source command line in $PATH; unlike
+// a command it does not have to be executable.
+func (r *Runner) lookSource(name string) (string, error) {
+	path, _ := r.getVar("PATH")
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+		p := filepath.Join(dir, name)
+		if fi, err := os.Stat(r.path(p)); err == nil && !fi.IsDir() {
+			return p, nil
+		}
+	}
+	return "", errNotFound
+}
@@ -0,0 +1,268 @@
+package interp
+
+import (
+	"maps"
+	"os"
+	"slices"
+	"sync/atomic"
+	"syscall"
+)
+
+// The subshells that run alongside the shell — pipeline members that the
+// shell runs itself, background lists, process substitutions and
+// coprocesses — are copies of the runner working in a goroutine, so that
+// they see all of the shell's state and need no shell binary.
+
+// subshellPidBase is the first of the numbers that subshells get in place
+// of a pid, for $! and kill. It is above the largest pid Linux hands out,
+// so none of them names a process.
+const subshellPidBase = 1 << 22
+
+var subshellPids atomic.Int64
+
+// subshell returns a copy of the shell to run a subshell with the
+// descriptor table fds. Like a forked shell, it starts with the variables,
+// functions, aliases, options, positional parameters and working directory
+// of the shell, with traps reset except for ignored signals and with no
+// jobs. Nothing it changes shows through to the shell.
+//
+// The subshell gets descriptors of its own for the files in fds, as a
+// forked process would, so that a pipe it writes to stays open until it is
+// done even if the shell closes its end.
+func (r *Runner) subshell(fds []*os.File) *Runner {
+	fds = slices.Clone(fds)
+	var coprocFds map[*os.File]bool
+	for i, f := range fds {
+		if f == nil {
+			continue
+		}
+		dup, err := dupFile(f)
+		if err != nil {
+			// Share the shell's descriptor rather than fail.
+			continue
+		}
+		if _, ok := r.coprocFds[f]; ok {
+			if coprocFds == nil {
+				coprocFds = map[*os.File]bool{}
+			}
+			coprocFds[dup] = false
+		}
+		fds[i] = dup
+	}
+	sub := &Runner{
+		ExecHandler:    r.ExecHandler,
+		OpenHandler:    r.OpenHandler,
+		started:        true,
+		stdin:          fds[0],
+		stdout:         fds[1],
+		stderr:         fds[2],
+		extraFds:       fds[3:],
+		dir:            r.dir,
+		vars:           cloneVars(r.vars),
+		lastStatus:     r.lastStatus,
+		lastBgPid:      r.lastBgPid,
+		scriptName:     r.scriptName,
+		positional:     r.positional,
+		functions:      maps.Clone(r.functions),
+		aliases:        maps.Clone(r.aliases),
+		sourceDepth:    r.sourceDepth,
+		dirStack:       slices.Clone(r.dirStack),
+		errexitIgnored: r.errexitIgnored,
+		substDepth:     r.substDepth,
+		coprocFds:      coprocFds,
+		ownFds:         fds,
+		traps:          subshellTraps(r.traps),
+		async:          true,
+		jobGroups:      r.jobGroups,
+		limits:         r.limits,
+		audit:          r.audit,
+	}
+	for _, scope := range r.localScopes {
+		sub.localScopes = append(sub.localScopes, cloneVars(scope))
+	}
+	sub.setOptionValues(r.optionValues())
+	hist := *r.hist
+	hist.entries = slices.Clone(hist.entries)
+	sub.hist = &hist
+	return sub
+}
+
+// cloneVars copies vars for a subshell, which must not change the
+// originals. Nil entries, which stand for unset variables in a local
+// scope, stay nil.
+func cloneVars(vars map[string]*variable) map[string]*variable {
+	c := make(map[string]*variable, len(vars))
+	for name, v := range vars {
+		if v != nil {
+			v = v.clone()
+		}
+		c[name] = v
+	}
+	return c
+}
+
+// subshellTraps returns the traps a subshell starts with: those that
+// ignore a signal.
+func subshellTraps(traps map[syscall.Signal]string) map[syscall.Signal]string {
+	kept := map[syscall.Signal]string{}
+	for sig, action := range traps {
+		if action == "" && sig != 0 {
+			kept[sig] = action
+		}
+	}
+	return kept
+}
+
+// goSubshell runs fn in the subshell sub on a goroutine, closing files
+// when it is done, and returns the proc that stands for it in a job.
+func goSubshell(sub *Runner, fn func(sub *Runner) int, files []*os.File) *proc {
+	p := &proc{pid: subshellPidBase + int(subshellPids.Add(1)), result: make(chan int, 1), sub: sub}
+	go func() {
+		status := sub.endSubshell(fn(sub))
+		closeFiles(files)
+		p.result <- status
+	}()
+	return p
+}
+
+// endSubshell finishes the subshell r, whose commands returned status, and
+// returns the status it ends with. As when a subshell process exits, its
+// descriptors and coprocess pipes are closed, and the jobs it leaves
+// running are no longer its concern; they are waited for in the background
+// so as not to linger as zombies.
+func (r *Runner) endSubshell(status int) int {
+	status = r.exitStatus(status)
+	for f, opened := range r.coprocFds {
+		if opened {
+			f.Close()
+		}
+	}
+	for _, f := range r.ownFds {
+		if f != nil {
+			f.Close()
+		}
+	}
+	for _, j := range r.jobTable {
+		for _, p := range j.procs {
+			if !p.done && p.result == nil && p.pid > 0 {
+				go syscall.Wait4(p.pid, nil, 0, nil)
+			}
+		}
+	}
+	return status
+}
+
+// exitStatus returns the status a subshell whose commands returned status
+// ends with: the one given to exit, if it ran it, after the EXIT trap.
+func (r *Runner) exitStatus(status int) int {
+	if r.exiting {
+		status = r.exitCode
+	}
+	r.exiting = false
+	if action, ok := r.traps[0]; ok {
+		r.lastStatus = status
+		r.runTrap(action)
+		if r.exiting {
+			status = r.exitCode
+		}
+	}
+	return status
+}
+
+// signal sends sig to the subshell r, as kill would to a subshell process.
+// The subshell acts on it before its next command, running its trap or
+// ending as the process would. An untrapped signal that ends it is passed
+// on to the commands it waits for, so that it gets there soon.
+func (r *Runner) signal(sig syscall.Signal) {
+	if sig == 0 {
+		return
+	}
+	r.queueSignal(sig)
+	r.sigMu.Lock()
+	_, trapped := r.traps[sig]
+	waiting := r.waiting
+	r.sigMu.Unlock()
+	if trapped || !terminates(sig) {
+		return
+	}
+	for _, p := range waiting {
+		switch {
+		case p.sub != nil:
+			p.sub.signal(sig)
+		case p.result == nil && p.pid > 0:
+			_ = syscall.Kill(p.pid, sig)
+		}
+	}
+}
+
+// signalSubshells passes sig on to the subshells in the job the shell is
+// waiting for.
+func (r *Runner) signalSubshells(sig syscall.Signal) {
+	r.sigMu.Lock()
+	waiting := r.waiting
+	r.sigMu.Unlock()
+	for _, p := range waiting {
+		if p.sub != nil {
+			p.sub.signal(sig)
+		}
+	}
+}
+
+// setWaiting records procs as the job the shell is waiting for, whose
+// subshells get the signals meant for the whole job.
+func (r *Runner) setWaiting(procs []*proc) {
+	r.sigMu.Lock()
+	r.waiting = procs
+	r.sigMu.Unlock()
+}
+
+// terminates reports whether sig ends a process that does not catch it.
+func terminates(sig syscall.Signal) bool {
+	switch sig {
+	case syscall.SIGCHLD, syscall.SIGCONT, syscall.SIGURG, syscall.SIGWINCH,
+		syscall.SIGSTOP, syscall.SIGTSTP, syscall.SIGTTIN, syscall.SIGTTOU:
+		return false
+	}
+	return true
+}
+
+// runPipelinePart runs c, a member of a pipeline that has to run in a
+// subshell, in the subshell r: a compound command, a function or a builtin
+// that changes the shell.
+func (r *Runner) runPipelinePart(c *Cmd) int {
+	if c.compound != nil {
+		return r.runCompound(c.compound)
+	}
+	return r.withTempVars(c.Env, func() int {
+		if f := r.functions[c.Args[0]]; f != nil {
+			return r.callFunction(f, c.Args)
+		}
+		c.Stdin, c.Stdout, c.Stderr = r.stdin, r.stdout, r.stderr
+		return r.runBuiltin(c)
+	})
+}
+
+// dupFile returns a new close-on-exec descriptor for the file f is open on,
+// in blocking mode as the commands given it expect.
+func dupFile(f *os.File) (*os.File, error) {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var fd uintptr
+	var errno syscall.Errno
+	err = rc.Control(func(old uintptr) {
+		fd, _, errno = syscall.Syscall(syscall.SYS_FCNTL, old, syscall.F_DUPFD_CLOEXEC, 0)
+	})
+	if err == nil && errno != 0 {
+		err = errno
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.SetNonblock(int(fd), false); err != nil {
+		syscall.Close(int(fd))
+		return nil, err
+	}
+	return os.NewFile(fd, f.Name()), nil
+}
@@ -0,0 +1,258 @@
+package interp
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// testError is a usage error in a test expression; test exits with 2.
+type testError string
+
+func (e testError) Error() string { return string(e) }
+
+// builtinTest evaluates a conditional expression. [ is the same command
+// but wants ] as its last argument.
+func (r *Runner) builtinTest(cmd *Cmd) int {
+	name, args := cmd.Args[0], cmd.Args[1:]
+	if name == "[" {
+		if len(args) == 0 || args[len(args)-1] != "]" {
+			fmt.Fprintln(cmd.Stderr, "[: missing `]'")
+			return 2
+		}
+		args = args[:len(args)-1]
+	}
+	ok, err := r.evalTest(args)
+	if err != nil {
+		fmt.Fprintf(cmd.Stderr, "%s: %v\n", name, err)
+		return 2
+	}
+	if ok {
+		return 0
+	}
+	return 1
+}
+
+func (r *Runner) evalTest(args []string) (ok bool, err error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			te, isTestErr := r.(testError)
+			if !isTestErr {
+				panic(r)
+			}
+			err = te
+		}
+	}()
+	t := &testParser{r: r, args: args}
+	ok = t.or()
+	if t.pos < len(t.args) {
+		panic(testError(t.args[t.pos] + ": unexpected argument"))
+	}
+	return ok, nil
+}
+
+// testParser evaluates a test expression by recursive descent, lowest
+// precedence first: -o, then -a, then !, then primaries and parentheses.
+type testParser struct {
+	r    *Runner
+	args []string
+	pos  int
+}
+
+func (t *testParser) peek(i int) (string, bool) {
+	if t.pos+i < len(t.args) {
+		return t.args[t.pos+i], true
+	}
+	return "", false
+}
+
+func (t *testParser) or() bool {
+	ok := t.and()
+	for {
+		if op, _ := t.peek(0); op != "-o" {
+			return ok
+		}
+		t.pos++
+		ok = t.and() || ok
+	}
+}
+
+func (t *testParser) and() bool {
+	ok := t.not()
+	for {
+		if op, _ := t.peek(0); op != "-a" {
+			return ok
+		}
+		t.pos++
+		ok = t.not() && ok
+	}
+}
+
+func (t *testParser) not() bool {
+	// "! = x" compares the string "!" rather than negating "= x".
+	if a, _ := t.peek(0); a == "!" {
+		if op, ok := t.peek(1); !ok || !(isBinaryTest(op) && len(t.args)-t.pos >= 3) {
+			t.pos++
+			if !ok {
+				return true
+			}
+			return !t.not()
+		}
+	}
+	return t.primary()
+}
+
+func (t *testParser) primary() bool {
+	a, ok := t.peek(0)
+	if !ok {
+		panic(testError("argument expected"))
+	}
+	if op, ok := t.peek(1); ok && isBinaryTest(op) && len(t.args)-t.pos >= 3 {
+		b, _ := t.peek(2)
+		t.pos += 3
+		return t.r.binaryTest(a, op, b)
+	}
+	if a == "(" {
+		t.pos++
+		ok := t.or()
+		if c, _ := t.peek(0); c != ")" {
+			panic(testError("`)' expected"))
+		}
+		t.pos++
+		return ok
+	}
+	if operand, ok := t.peek(1); ok && isUnaryTest(a) {
+		t.pos += 2
+		return t.r.unaryTest(a, operand)
+	}
+	t.pos++
+	return a != ""
+}
+
+func isUnaryTest(op string) bool {
+	return len(op) == 2 && op[0] == '-' && strings.IndexByte("bcdefghkLnOpGrsStuvwxz", op[1]) >= 0
+}
+
+func isBinaryTest(op string) bool {
+	switch op {
+	case "=", "==", "!=", "<", ">", "-eq", "-ne", "-lt", "-le", "-gt", "-ge", "-nt", "-ot", "-ef":
+		return true
+	}
+	return false
+}
+
+func (r *Runner) unaryTest(op, s string) bool {
+	switch op {
+	case "-n":
+		return s != ""
+	case "-z":
+		return s == ""
+	case "-v":
+		_, ok := r.getVar(s)
+		return ok
+	case "-t":
+		return isTerminal(testInt(s))
+	}
+	if s == "" {
+		return false
+	}
+	s = r.path(s)
+	switch op {
+	case "-r":
+		return syscall.Access(s, 4) == nil
+	case "-w":
+		return syscall.Access(s, 2) == nil
+	case "-x":
+		return syscall.Access(s, 1) == nil
+	case "-h", "-L":
+		fi, err := os.Lstat(s)
+		return err == nil && fi.Mode()&os.ModeSymlink != 0
+	}
+	fi, err := os.Stat(s)
+	if err != nil {
+		return false
+	}
+	mode := fi.Mode()
+	switch op {
+	case "-e":
+		return true
+	case "-f":
+		return mode.IsRegular()
+	case "-d":
+		return mode.IsDir()
+	case "-s":
+		return fi.Size() > 0
+	case "-p":
+		return mode&os.ModeNamedPipe != 0
+	case "-S":
+		return mode&os.ModeSocket != 0
+	case "-b":
+		return mode&os.ModeDevice != 0 && mode&os.ModeCharDevice == 0
+	case "-c":
+		return mode&os.ModeCharDevice != 0
+	case "-u":
+		return mode&os.ModeSetuid != 0
+	case "-g":
+		return mode&os.ModeSetgid != 0
+	case "-k":
+		return mode&os.ModeSticky != 0
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	if op == "-O" {
+		return int(st.Uid) == os.Geteuid()
+	}
+	return int(st.Gid) == os.Getegid()
+}
+
+func (r *Runner) binaryTest(a, op, b string) bool {
+	switch op {
+	case "=", "==":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case ">":
+		return a > b
+	case "-nt", "-ot", "-ef":
+		fa, errA := os.Stat(r.path(a))
+		fb, errB := os.Stat(r.path(b))
+		switch {
+		case op == "-ef":
+			return errA == nil && errB == nil && os.SameFile(fa, fb)
+		case op == "-nt":
+			return errA == nil && (errB != nil || fa.ModTime().After(fb.ModTime()))
+		}
+		return errB == nil && (errA != nil || fa.ModTime().Before(fb.ModTime()))
+	}
+	x, y := testInt(a), testInt(b)
+	switch op {
+	case "-eq":
+		return x == y
+	case "-ne":
+		return x != y
+	case "-lt":
+		return x < y
+	case "-le":
+		return x <= y
+	case "-gt":
+		return x > y
+	}
+	return x >= y
+}
+
+func testInt(s string) int {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		panic(testError(s + ": integer expression expected"))
+	}
+	return n
+}
@@ -0,0 +1,422 @@
+package interp
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type variable struct {
+	value    string
+	exported bool
+	// array holds the elements of an indexed array that are set, by index,
+	// and keys their indexes in order; value mirrors element 0, which is
+	// what the plain name expands to.
+	array map[int]string
+	keys  []int
+}
+
+// clone returns a copy of v that can be changed without changing v.
+func (v *variable) clone() *variable {
+	c := *v
+	c.array = maps.Clone(v.array)
+	c.keys = slices.Clone(v.keys)
+	return &c
+}
+
+// elems returns the elements of v in order: those of an array that are
+// set, or the value of any other variable.
+func (v *variable) elems() []string {
+	if v.array == nil {
+		return []string{v.value}
+	}
+	elems := make([]string, len(v.keys))
+	for i, k := range v.keys {
+		elems[i] = v.array[k]
+	}
+	return elems
+}
+
+// index turns a negative index into one counting back from the end of v,
+// one past its highest set element.
+func (v *variable) index(i int) int {
+	if i >= 0 {
+		return i
+	}
+	if v.array == nil {
+		return i + 1
+	}
+	if len(v.keys) == 0 {
+		return i
+	}
+	return i + v.keys[len(v.keys)-1] + 1
+}
+
+// setElem sets element i of the array v.
+func (v *variable) setElem(i int, value string) {
+	if v.array == nil {
+		v.array = map[int]string{}
+	}
+	if _, ok := v.array[i]; !ok {
+		at, _ := slices.BinarySearch(v.keys, i)
+		v.keys = slices.Insert(v.keys, at, i)
+	}
+	v.array[i] = value
+	if i == 0 {
+		v.value = value
+	}
+}
+
+// unsetElem unsets element i of the array v.
+func (v *variable) unsetElem(i int) {
+	if _, ok := v.array[i]; !ok {
+		return
+	}
+	delete(v.array, i)
+	at, _ := slices.BinarySearch(v.keys, i)
+	v.keys = slices.Delete(v.keys, at, at+1)
+	if i == 0 {
+		v.value = ""
+	}
+}
+
+var errNotFound = errors.New("command not found")
+
+func (r *Runner) initVars() {
+	env := r.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	for _, kv := range env {
+		name, value, ok := strings.Cut(kv, "=")
+		if ok && isName(name) {
+			r.vars[name] = &variable{value: value, exported: true}
+		}
+	}
+	r.initPwd()
+}
+
+func (r *Runner) getVar(name string) (string, bool) {
+	v, ok := r.vars[name]
+	if !ok {
+		return "", false
+	}
+	return v.value, true
+}
+
+func (r *Runner) setVar(name, value string) {
+	if v, ok := r.vars[name]; ok {
+		v.value = value
+		if v.array != nil {
+			v.setElem(0, value)
+		}
+		return
+	}
+	r.vars[name] = &variable{value: value}
+}
+
+// setArray makes name an indexed array holding values.
+func (r *Runner) setArray(name string, values []string) {
+	v, ok := r.vars[name]
+	if !ok {
+		v = &variable{}
+		r.vars[name] = v
+	}
+	v.array, v.keys, v.value = map[int]string{}, nil, ""
+	for i, value := range values {
+		v.setElem(i, value)
+	}
+}
+
+// arrayAssign is an expanded NAME=(values), or NAME[index]=value when
+// whole is not set.
+type arrayAssign struct {
+	name   string
+	whole  bool
+	index  int
+	values []string
+}
+
+// assignArray makes the assignment aa. A negative index counts back from
+// the end of the array. Arrays are sparse: assigning past the end leaves
+// the elements in between unset.
+func (r *Runner) assignArray(aa arrayAssign) error {
+	if aa.whole {
+		r.setArray(aa.name, aa.values)
+		return nil
+	}
+	v, ok := r.vars[aa.name]
+	if !ok {
+		v = &variable{array: map[int]string{}}
+	}
+	i := v.index(aa.index)
+	if i < 0 {
+		return fmt.Errorf("%s[%d]: bad array subscript", aa.name, aa.index)
+	}
+	if v.array == nil {
+		v.setElem(0, v.value)
+	}
+	v.setElem(i, aa.values[0])
+	r.vars[aa.name] = v
+	return nil
+}
+
+// getElem returns element index of the array name, counting back from
+// the end if it is negative. A variable that is not an array is one of a
+// single element.
+func (r *Runner) getElem(name string, index int) (string, bool) {
+	v, ok := r.vars[name]
+	if !ok {
+		return "", false
+	}
+	i := v.index(index)
+	if v.array == nil {
+		return v.value, i == 0
+	}
+	s, ok := v.array[i]
+	return s, ok
+}
+
+// unsetElem unsets element index of the array name, counting back from
+// the end if it is negative. Unsetting element 0 of a variable that is not
+// an array unsets the variable.
+func (r *Runner) unsetElem(name string, index int) error {
+	v, ok := r.vars[name]
+	if !ok {
+		return nil
+	}
+	i := v.index(index)
+	if i < 0 {
+		return fmt.Errorf("%s[%d]: bad array subscript", name, index)
+	}
+	if v.array == nil {
+		if i == 0 {
+			r.unsetVar(name)
+		}
+		return nil
+	}
+	v.unsetElem(i)
+	return nil
+}
+
+func (r *Runner) unsetVar(name string) {
+	delete(r.vars, name)
+}
+
+// environ returns the exported variables with overrides applied on top, in
+// the NAME=value form expected by exec.
+func (r *Runner) environ(overrides []string) []string {
+	env := map[string]string{}
+	for name, v := range r.vars {
+		if v.exported {
+			env[name] = v.value
+		}
+	}
+	for _, kv := range overrides {
+		name, value, _ := strings.Cut(kv, "=")
+		env[name] = value
+	}
+	out := make([]string, 0, len(env))
+	for name, value := range env {
+		out = append(out, name+"="+value)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// withTempVars runs fn with the NAME=value assignments in env applied and
+// restores the previous values afterwards.
+func (r *Runner) withTempVars(env []string, fn func() int) int {
+	saved := map[string]*variable{}
+	for _, kv := range env {
+		name, value, _ := strings.Cut(kv, "=")
+		if _, done := saved[name]; !done {
+			if v, ok := r.vars[name]; ok {
+				saved[name] = v.clone()
+			} else {
+				saved[name] = nil
+			}
+		}
+		r.setVar(name, value)
+	}
+	defer func() {
+		for name, old := range saved {
+			if old == nil {
+				delete(r.vars, name)
+			} else {
+				r.vars[name] = old
+			}
+		}
+	}()
+	return fn()
+}
+
+// lookPath finds the executable to run for name, relative to the shell's
+// working directory.
+func (r *Runner) lookPath(name string, env []string) (string, error) {
+	if strings.Contains(name, "/") {
+		return r.path(name), nil
+	}
+	path, _ := r.getVar("PATH")
+	for _, kv := range env {
+		if v, ok := strings.CutPrefix(kv, "PATH="); ok {
+			path = v
+		}
+	}
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+		p := r.path(filepath.Join(dir, name))
+		if fi, err := os.Stat(p); err == nil && !fi.IsDir() && fi.Mode()&0111 != 0 {
+			return p, nil
+		}
+	}
+	return "", errNotFound
+}
+
+// quoteArgs joins args into a command line that reads back as the same words.
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = shellQuote(a)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r == '_' || r == '-' || r == '.' || r == '/' || r == ':' || r == ',' || r == '+' || r == '@' || r == '%' || r == '=' ||
+			(r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func (r *Runner) specialParam(name string) (string, bool) {
+	switch name {
+	case "0":
+		return r.scriptName, true
+	case "#":
+		return strconv.Itoa(len(r.positional)), true
+	case "@", "*":
+		return strings.Join(r.positional, " "), true
+	case "?":
+		return strconv.Itoa(r.lastStatus), true
+	case "$":
+		return strconv.Itoa(os.Getpid()), true
+	case "!":
+		if r.lastBgPid == 0 {
+			return "", true
+		}
+		return strconv.Itoa(r.lastBgPid), true
+	case "-":
+		return r.flags(), true
+	}
+	if n, err := strconv.Atoi(name); err == nil {
+		if n >= 1 && n <= len(r.positional) {
+			return r.positional[n-1], true
+		}
+		return "", true
+	}
+	return "", false
+}
+
+// paramSet reports whether the special parameter name has a value, for
+// set -u.
+func (r *Runner) paramSet(name string) bool {
+	if name == "!" {
+		return r.lastBgPid != 0
+	}
+	if n, err := strconv.Atoi(name); err == nil && n > 0 {
+		return n <= len(r.positional)
+	}
+	return true
+}
+
+func (r *Runner) builtinExport(cmd *Cmd) int {
+	args := cmd.Args[1:]
+	unexport := false
+	if len(args) > 0 && args[0] == "-n" {
+		unexport = true
+		args = args[1:]
+	} else if len(args) > 0 && args[0] == "-p" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		names := make([]string, 0, len(r.vars))
+		for name, v := range r.vars {
+			if v.exported {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(cmd.Stdout, "export %s=%s\n", name, shellQuote(r.vars[name].value))
+		}
+		return 0
+	}
+	status := 0
+	for _, arg := range args {
+		name, value, hasValue := strings.Cut(arg, "=")
+		if !isName(name) {
+			fmt.Fprintf(cmd.Stderr, "export: `%s': not a valid identifier\n", arg)
+			status = 1
+			continue
+		}
+		if hasValue {
+			r.setVar(name, value)
+		}
+		v, ok := r.vars[name]
+		if !ok {
+			if unexport {
+				continue
+			}
+			v = &variable{}
+			r.vars[name] = v
+		}
+		v.exported = !unexport
+	}
+	return status
+}
+
+func (r *Runner) builtinUnset(cmd *Cmd) int {
+	args := cmd.Args[1:]
+	funcs := false
+	if len(args) > 0 && (args[0] == "-v" || args[0] == "-f") {
+		funcs = args[0] == "-f"
+		args = args[1:]
+	}
+	status := 0
+	for _, name := range args {
+		if funcs {
+			delete(r.functions, name)
+			continue
+		}
+		if base, sub, ok := strings.Cut(name, "["); ok && isName(base) && strings.HasSuffix(sub, "]") {
+			i, err := r.evalArith(strings.TrimSuffix(sub, "]"))
+			if err == nil {
+				err = r.unsetElem(base, int(i))
+			}
+			if err != nil {
+				fmt.Fprintln(cmd.Stderr, "unset:", err)
+				status = 1
+			}
+			continue
+		}
+		if !isName(name) {
+			fmt.Fprintf(cmd.Stderr, "unset: `%s': not a valid identifier\n", name)
+			status = 1
+			continue
+		}
+		r.unsetVar(name)
+	}
+	return status
+}
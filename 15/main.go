@@ -1,485 +1,198 @@
 package main
 
 import (
-	"bufio"
-	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
-	"os/signal"
-	"strconv"
 	"strings"
-	"syscall"
-)
-
-type Cmd struct {
-	Args   []string
-	In     string
-	Out    string
-	Append bool
-}
-
-type Job struct {
-	Cmds []*Cmd
-	Cond string
-}
 
-var currentCmdProcs []*os.Process
+	"gitlab.com/arkine/l2/15/interp"
+)
 
 func main() {
-	reader := bufio.NewReader(os.Stdin)
-
-	sigc := make(chan os.Signal, 1)
-	signal.Notify(sigc, os.Interrupt)
-	go func() {
-		for range sigc {
-			for _, p := range currentCmdProcs {
-				if p == nil {
-					continue
-				}
-				_ = p.Signal(syscall.SIGINT)
-			}
-		}
-	}()
-
-	for {
-		cwd, _ := os.Getwd()
-		fmt.Printf("%s$ ", cwd)
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				fmt.Println()
-				return
-			}
-			fmt.Fprintln(os.Stderr, "read error:", err)
-			continue
-		}
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		jobs, perr := parseLine(line)
-		if perr != nil {
-			fmt.Fprintln(os.Stderr, "parse error:", perr)
-			continue
-		}
-
-		lastStatus := 0
-		for i, job := range jobs {
-			if i > 0 {
-				prevCond := job.Cond
-				if prevCond == "&&" && lastStatus != 0 {
-					continue
-				}
-				if prevCond == "||" && lastStatus == 0 {
-					continue
-				}
-			}
-
-			status := runJob(job)
-			lastStatus = status
-		}
+	command := flag.String("c", "", "read commands from the `string` and exit")
+	norc := flag.Bool("norc", false, "do not read ~/.goshrc in an interactive shell")
+	check := flag.Bool("n", false, "report the syntax errors in the scripts without running them")
+	format := flag.Bool("fmt", false, "print the scripts in canonical form instead of running them")
+	audit := flag.String("audit", "", "record each pipeline run as a JSON line in the file or Unix socket at `path`")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-eux] [+eux] [-o option] [+o option] [flags] [script [arg ...]]\n", os.Args[0])
+		fmt.Fprintln(flag.CommandLine.Output(), "  -e, -u, -x and -o option turn options on as set does, and + turns them off")
+		flag.PrintDefaults()
 	}
-}
-
-func parseLine(line string) ([]*Job, error) {
-	var jobs []*Job
-	s := strings.TrimSpace(line)
-	if s == "" {
-		return jobs, nil
+	options, args, err := setOptions(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(2)
 	}
+	flag.CommandLine.Parse(args)
 
-	parts := splitByLogical(s)
-	for _, p := range parts {
-		trimmed := strings.TrimSpace(p.text)
-		if trimmed == "" {
-			continue
-		}
-		cmds, err := parsePipeline(trimmed)
-		if err != nil {
-			return nil, err
-		}
-		jobs = append(jobs, &Job{Cmds: cmds, Cond: p.cond})
+	if *check || *format {
+		os.Exit(checkScripts(*command, *format))
 	}
-	return jobs, nil
-}
-
-type logicalPart struct {
-	text string
-	cond string
-}
 
-func splitByLogical(s string) []logicalPart {
-	var res []logicalPart
-	cur := ""
-	cond := ""
-	inS := false
-	inD := false
-	for i := 0; i < len(s); i++ {
-		c := s[i]
-		if c == '\'' && !inD {
-			inS = !inS
-		}
-		if c == '"' && !inS {
-			inD = !inD
-		}
-		if !inS && !inD && i+1 < len(s) {
-			two := s[i : i+2]
-			if two == "&&" || two == "||" {
-				res = append(res, logicalPart{text: cur, cond: cond})
-				cur = ""
-				cond = two
-				i++
-				continue
-			}
+	r := &interp.Runner{Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr}
+	if flag.NArg() > 0 {
+		r.Name, r.Params = flag.Arg(0), flag.Args()[1:]
+	}
+	for _, o := range options {
+		if err := r.SetOption(o.name, o.on); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
 		}
-		cur += string(c)
 	}
-	res = append(res, logicalPart{text: cur, cond: cond})
-	if len(res) > 0 {
-		res[0].cond = ""
+	if *audit != "" {
+		if err := r.SetAudit(*audit); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
 	}
-	return res
-}
+	r.CatchSignals()
 
-func parsePipeline(s string) ([]*Cmd, error) {
-	parts := splitTopLevel(s, '|')
-	var cmds []*Cmd
-	for _, p := range parts {
-		p = strings.TrimSpace(p)
-		if p == "" {
-			continue
-		}
-		cmd, err := parseCmd(p)
+	if isFlagSet("c") {
+		os.Exit(r.Exit(runScript(r, *command)))
+	}
+	if flag.NArg() > 0 {
+		data, err := os.ReadFile(r.Name)
 		if err != nil {
-			return nil, err
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(127)
 		}
-		cmds = append(cmds, cmd)
+		os.Exit(r.Exit(runScript(r, string(data))))
 	}
-	return cmds, nil
-}
 
-func splitTopLevel(s string, sep rune) []string {
-	var res []string
-	cur := strings.Builder{}
-	inS := false
-	inD := false
-	for _, r := range s {
-		if r == '\'' && !inD {
-			inS = !inS
-		}
-		if r == '"' && !inS {
-			inD = !inD
+	if r.EnableJobControl() && !*norc {
+		if status := r.LoadRC(); r.Exited() {
+			os.Exit(r.Exit(status))
 		}
-		if r == sep && !inS && !inD {
-			res = append(res, cur.String())
-			cur.Reset()
-			continue
-		}
-		cur.WriteRune(r)
 	}
-	res = append(res, cur.String())
-	return res
+	os.Exit(r.Exit(r.RunInteractive()))
 }
 
-func parseCmd(s string) (*Cmd, error) {
-	tokens := tokenize(s)
-	if len(tokens) == 0 {
-		return nil, errors.New("empty command")
-	}
-	cmd := &Cmd{}
-	i := 0
-	for i < len(tokens) {
-		t := tokens[i]
-		if t == ">" || t == ">>" {
-			appendMode := t == ">>"
-			i++
-			if i >= len(tokens) {
-				return nil, errors.New("no file after >")
-			}
-			cmd.Out = tokens[i]
-			cmd.Append = appendMode
-		} else if t == "<" {
-			i++
-			if i >= len(tokens) {
-				return nil, errors.New("no file after <")
-			}
-			cmd.In = tokens[i]
-		} else {
-			arg := expandEnv(t)
-			cmd.Args = append(cmd.Args, arg)
-		}
-		i++
-	}
-	return cmd, nil
+type shellOption struct {
+	name string
+	on   bool
 }
 
-func tokenize(s string) []string {
-	var res []string
-	cur := strings.Builder{}
-	inS := false
-	inD := false
-	i := 0
-	for i < len(s) {
-		c := s[i]
-		if c == '\'' && !inD {
-			inS = !inS
-			i++
-			continue
-		}
-		if c == '"' && !inS {
-			inD = !inD
-			i++
-			continue
-		}
-		if !inS && !inD && (c == ' ' || c == '\t') {
-			if cur.Len() > 0 {
-				res = append(res, cur.String())
-				cur.Reset()
-			}
-			i++
-			continue
-		}
-		if !inS && !inD && (c == '>' || c == '<') {
-			if cur.Len() > 0 {
-				res = append(res, cur.String())
-				cur.Reset()
-			}
-			if c == '>' && i+1 < len(s) && s[i+1] == '>' {
-				res = append(res, ">>")
-				i += 2
-				continue
+// optionLetters are the options that set takes as single letters.
+var optionLetters = map[byte]string{'e': "errexit", 'u': "nounset", 'x': "xtrace"}
+
+// setOptions takes the options set also accepts out of args, up to the
+// first operand, and returns them in order with the arguments left for the
+// flag package. As for set, -e, -u and -x turn errexit, nounset and xtrace
+// on and -o turns on the option named by the next argument; + in place of
+// - turns them off. Letters can be grouped, as in -eux or -euo pipefail,
+// and the group can hold -c and -n, which go to the flag package.
+func setOptions(args []string) ([]shellOption, []string, error) {
+	var options []shellOption
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || len(arg) < 2 || arg[0] != '-' && arg[0] != '+' {
+			return options, append(rest, args[i:]...), nil
+		}
+		on := arg[0] == '-'
+		if strings.Trim(arg[1:], "euxocn") != "" {
+			// A flag of the flag package, such as -norc or -audit path.
+			rest = append(rest, arg)
+			if name := strings.TrimLeft(arg, "-"); (name == "audit" || name == "c") && i+1 < len(args) {
+				i++
+				rest = append(rest, args[i])
 			}
-			res = append(res, string(c))
-			i++
 			continue
 		}
-		cur.WriteByte(c)
-		i++
-	}
-	if cur.Len() > 0 {
-		res = append(res, cur.String())
-	}
-	return res
-}
-
-func expandEnv(s string) string {
-	out := strings.Builder{}
-	i := 0
-	for i < len(s) {
-		c := s[i]
-		if c == '$' {
-			if i+1 < len(s) && s[i+1] == '{' {
-				j := i + 2
-				for j < len(s) && s[j] != '}' {
-					j++
+		for _, letter := range []byte(arg[1:]) {
+			switch {
+			case optionLetters[letter] != "":
+				options = append(options, shellOption{optionLetters[letter], on})
+			case letter == 'o' || on && letter == 'c':
+				if i+1 == len(args) {
+					return nil, nil, fmt.Errorf("%c%c: option requires an argument", arg[0], letter)
 				}
-				if j < len(s) && s[j] == '}' {
-					name := s[i+2 : j]
-					out.WriteString(os.Getenv(name))
-					i = j + 1
-					continue
-				}
-				out.WriteByte(c)
 				i++
-				continue
-			} else {
-				j := i + 1
-				for j < len(s) && (isAlnumUnderscore(s[j])) {
-					j++
+				if letter == 'o' {
+					options = append(options, shellOption{args[i], on})
+				} else {
+					rest = append(rest, "-c", args[i])
 				}
-				name := s[i+1 : j]
-				out.WriteString(os.Getenv(name))
-				i = j
-				continue
+			case on && letter == 'n':
+				rest = append(rest, "-n")
+			default:
+				return nil, nil, fmt.Errorf("%c%c: invalid option", arg[0], letter)
 			}
 		}
-		out.WriteByte(c)
-		i++
 	}
-	return out.String()
+	return options, rest, nil
 }
 
-func isAlnumUnderscore(b byte) bool {
-	if (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9') || b == '_' {
-		return true
-	}
-	return false
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
 }
 
-func runJob(job *Job) int {
-	if len(job.Cmds) == 0 {
-		return 0
-	}
-
-	if len(job.Cmds) == 1 && isBuiltin(job.Cmds[0].Args) {
-		return runBuiltin(job.Cmds[0])
-	}
-
-	n := len(job.Cmds)
-	cmds := make([]*exec.Cmd, n)
-	pipes := make([]*os.File, 2*(n-1))
-	for i := 0; i < n-1; i++ {
-		r, w, err := os.Pipe()
+// checkScripts reads the -c string, the files named or standard input and
+// reports all their syntax errors as file:line:col, or with format prints
+// them in canonical form.
+func checkScripts(command string, format bool) int {
+	type script struct{ name, src string }
+	var scripts []script
+	status := 0
+	switch {
+	case isFlagSet("c"):
+		scripts = append(scripts, script{"-c", command})
+	case flag.NArg() == 0:
+		data, err := io.ReadAll(os.Stdin)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "pipe error:", err)
+			fmt.Fprintln(os.Stderr, err)
 			return 1
 		}
-		pipes[2*i] = r
-		pipes[2*i+1] = w
-	}
-
-	for i, c := range job.Cmds {
-		if len(c.Args) == 0 {
-			continue
-		}
-		cmds[i] = exec.Command(c.Args[0], c.Args[1:]...)
-		cmds[i].SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-		if c.In != "" {
-			f, err := os.Open(c.In)
-			if err != nil {
-				fmt.Fprintln(os.Stderr, "open infile:", err)
-				return 1
-			}
-			cmds[i].Stdin = f
-		} else if i > 0 {
-			cmds[i].Stdin = pipes[2*(i-1)]
-		}
-		if c.Out != "" {
-			flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
-			if c.Append {
-				flag = os.O_CREATE | os.O_WRONLY | os.O_APPEND
-			}
-			f, err := os.OpenFile(c.Out, flag, 0644)
+		scripts = append(scripts, script{"<stdin>", string(data)})
+	default:
+		for _, name := range flag.Args() {
+			data, err := os.ReadFile(name)
 			if err != nil {
-				fmt.Fprintln(os.Stderr, "open outfile:", err)
-				return 1
+				fmt.Fprintln(os.Stderr, err)
+				status = 127
+				continue
 			}
-			cmds[i].Stdout = f
-		} else if i < n-1 {
-			cmds[i].Stdout = pipes[2*i+1]
-		} else {
-			cmds[i].Stdout = os.Stdout
+			scripts = append(scripts, script{name, string(data)})
 		}
-		cmds[i].Stderr = os.Stderr
 	}
-
-	currentCmdProcs = []*os.Process{}
-	for i, c := range cmds {
-		if c == nil {
-			continue
-		}
-		if err := c.Start(); err != nil {
-			fmt.Fprintln(os.Stderr, "start error:", err)
-			for _, p := range pipes {
-				if p != nil {
-					p.Close()
-				}
+	for _, s := range scripts {
+		if format {
+			out, err := interp.Format(s.src)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s:%v\n", s.name, err)
+				status = max(status, 2)
+				continue
 			}
-			return 1
-		}
-		currentCmdProcs = append(currentCmdProcs, c.Process)
-		if i > 0 {
-		}
-	}
-
-	for _, p := range pipes {
-		if p != nil {
-			p.Close()
-		}
-	}
-
-	status := 0
-	for _, c := range cmds {
-		if c == nil {
+			fmt.Print(out)
 			continue
 		}
-		err := c.Wait()
-		if err != nil {
-			if exiterr, ok := err.(*exec.ExitError); ok {
-				if ws, ok := exiterr.Sys().(syscall.WaitStatus); ok {
-					status = ws.ExitStatus()
-				}
-			} else {
-				fmt.Fprintln(os.Stderr, "wait error:", err)
-				status = 1
-			}
-		} else {
-			status = 0
+		for _, err := range interp.Check(s.src) {
+			fmt.Fprintf(os.Stderr, "%s:%v\n", s.name, err)
+			status = max(status, 2)
 		}
 	}
-	currentCmdProcs = []*os.Process{}
 	return status
 }
 
-func isBuiltin(args []string) bool {
-	if len(args) == 0 {
-		return false
-	}
-	switch args[0] {
-	case "cd", "pwd", "echo", "kill", "ps":
-		return true
-	}
-	return false
-}
-
-func runBuiltin(cmd *Cmd) int {
-	if len(cmd.Args) == 0 {
-		return 0
-	}
-	switch cmd.Args[0] {
-	case "cd":
-		if len(cmd.Args) < 2 {
-			home := os.Getenv("HOME")
-			if home == "" {
-				home = "/"
-			}
-			if err := os.Chdir(home); err != nil {
-				fmt.Fprintln(os.Stderr, "cd:", err)
-				return 1
-			}
-			return 0
-		}
-		path := cmd.Args[1]
-		if err := os.Chdir(path); err != nil {
-			fmt.Fprintln(os.Stderr, "cd:", err)
-			return 1
-		}
-		return 0
-	case "pwd":
-		wd, _ := os.Getwd()
-		fmt.Println(wd)
-		return 0
-	case "echo":
-		args := cmd.Args[1:]
-		fmt.Println(strings.Join(args, " "))
-		return 0
-	case "kill":
-		if len(cmd.Args) < 2 {
-			fmt.Fprintln(os.Stderr, "kill: pid required")
-			return 1
+// runScript runs src, reporting a syntax error against the script name.
+func runScript(r *interp.Runner, src string) int {
+	status, err := r.Run(src)
+	if err != nil {
+		name := r.Name
+		if name == "" {
+			name = os.Args[0]
 		}
-		p, err := strconv.Atoi(cmd.Args[1])
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "kill: bad pid")
-			return 1
-		}
-		if err := syscall.Kill(p, syscall.SIGTERM); err != nil {
-			fmt.Fprintln(os.Stderr, "kill:", err)
-			return 1
-		}
-		return 0
-	case "ps":
-		c := exec.Command("ps", "-aux")
-		c.Stdout = os.Stdout
-		c.Stderr = os.Stderr
-		if err := c.Run(); err != nil {
-			fmt.Fprintln(os.Stderr, "ps:", err)
-			return 1
-		}
-		return 0
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
 	}
-	return 0
+	return status
 }